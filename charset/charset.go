@@ -0,0 +1,213 @@
+// Package charset converts the contents of files between their encoding and
+// the UTF-8 text the editor works with.
+//
+// Decoding never fails and nothing is lost: whatever can't be decoded is
+// kept in the text in a form that encodes back to the same bytes. Invalid
+// UTF-8 stays as it is, and unpaired UTF-16 surrogates are written as they
+// would be in UTF-8 if it allowed them.
+package charset
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// An Encoding is a way of writing text as bytes. Files in an encoding with
+// a BOM start with it, and it isn't part of the text.
+type Encoding struct {
+	Name    string
+	Aliases []string
+	BOM     []byte
+	// decode decodes contents without the BOM. Any incomplete sequence at
+	// the end is returned separately, as rest.
+	decode func(contents []byte) (text string, rest []byte)
+	encode func(text string) ([]byte, error)
+}
+
+var (
+	UTF8 = &Encoding{
+		Name:    "utf-8",
+		Aliases: []string{"utf8"},
+		decode:  func(b []byte) (string, []byte) { return string(b), nil },
+		encode:  func(s string) ([]byte, error) { return []byte(s), nil },
+	}
+	UTF8BOM = &Encoding{
+		Name:    "utf-8-bom",
+		Aliases: []string{"utf8-bom"},
+		BOM:     []byte{0xef, 0xbb, 0xbf},
+		decode:  UTF8.decode,
+		encode:  UTF8.encode,
+	}
+	UTF16LE = &Encoding{
+		Name:    "utf-16le",
+		Aliases: []string{"utf16le"},
+		BOM:     []byte{0xff, 0xfe},
+		decode: func(b []byte) (string, []byte) {
+			return decodeUTF16(b, func(b []byte) uint16 {
+				return uint16(b[0]) | uint16(b[1])<<8
+			})
+		},
+		encode: func(s string) ([]byte, error) {
+			return encodeUTF16(s, func(u uint16) []byte {
+				return []byte{byte(u), byte(u >> 8)}
+			})
+		},
+	}
+	UTF16BE = &Encoding{
+		Name:    "utf-16be",
+		Aliases: []string{"utf16be"},
+		BOM:     []byte{0xfe, 0xff},
+		decode: func(b []byte) (string, []byte) {
+			return decodeUTF16(b, func(b []byte) uint16 {
+				return uint16(b[0])<<8 | uint16(b[1])
+			})
+		},
+		encode: func(s string) ([]byte, error) {
+			return encodeUTF16(s, func(u uint16) []byte {
+				return []byte{byte(u >> 8), byte(u)}
+			})
+		},
+	}
+	Latin1 = &Encoding{
+		Name:    "latin1",
+		Aliases: []string{"latin-1", "iso-8859-1", "iso8859-1"},
+		decode:  decodeLatin1,
+		encode:  encodeLatin1,
+	}
+)
+
+// Encodings lists every encoding, with those checked first by Detect first.
+var Encodings = []*Encoding{UTF8BOM, UTF16LE, UTF16BE, UTF8, Latin1}
+
+// Find returns the encoding called name, or nil if there isn't one. Names
+// are case insensitive.
+func Find(name string) *Encoding {
+	for _, e := range Encodings {
+		if strings.EqualFold(e.Name, name) {
+			return e
+		}
+		for _, alias := range e.Aliases {
+			if strings.EqualFold(alias, name) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Detect returns the encoding of contents: whichever its BOM says, or UTF-8
+// if it's valid UTF-8, or Latin-1, which any bytes are valid in, otherwise.
+func Detect(contents []byte) *Encoding {
+	for _, e := range Encodings {
+		if e.BOM != nil && bytes.HasPrefix(contents, e.BOM) {
+			return e
+		}
+	}
+	if utf8.Valid(contents) {
+		return UTF8
+	}
+	return Latin1
+}
+
+// Decode decodes contents, returning the text along with any bytes at the
+// end which are too few to decode, which Encode needs to write the same
+// contents back.
+func (e *Encoding) Decode(contents []byte) (text string, rest []byte) {
+	return e.decode(bytes.TrimPrefix(contents, e.BOM))
+}
+
+// Encode encodes text followed by rest. It fails if the text has characters
+// that can't be written in e.
+func (e *Encoding) Encode(text string, rest []byte) ([]byte, error) {
+	b, err := e.encode(text)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(append([]byte{}, e.BOM...), b...), rest...), nil
+}
+
+func decodeUTF16(b []byte, unit func([]byte) uint16) (string, []byte) {
+	var sb strings.Builder
+	for i := 0; i+1 < len(b); i += 2 {
+		u := unit(b[i:])
+		if utf16.IsSurrogate(rune(u)) && i+3 < len(b) {
+			r := utf16.DecodeRune(rune(u), rune(unit(b[i+2:])))
+			if r != utf8.RuneError {
+				sb.WriteRune(r)
+				i += 2
+				continue
+			}
+		}
+		if utf16.IsSurrogate(rune(u)) {
+			// Unpaired surrogates aren't valid in UTF-8, so utf8.EncodeRune
+			// won't write them.
+			sb.WriteByte(0xe0 | byte(u>>12))
+			sb.WriteByte(0x80 | byte(u>>6)&0x3f)
+			sb.WriteByte(0x80 | byte(u)&0x3f)
+			continue
+		}
+		sb.WriteRune(rune(u))
+	}
+	return sb.String(), b[len(b)-len(b)%2:]
+}
+
+func encodeUTF16(s string, unit func(uint16) []byte) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			if !isSurrogate(s[i:]) {
+				return nil, fmt.Errorf("can't write byte %#x as utf-16", s[i])
+			}
+			u := 0xd000 | uint16(s[i+1]&0x3f)<<6 | uint16(s[i+2]&0x3f)
+			b = append(b, unit(u)...)
+			i += 3
+			continue
+		}
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			b = append(b, unit(uint16(r1))...)
+			b = append(b, unit(uint16(r2))...)
+		} else {
+			b = append(b, unit(uint16(r))...)
+		}
+		i += size
+	}
+	return b, nil
+}
+
+// isSurrogate reports whether s starts with a surrogate as decodeUTF16
+// writes it.
+func isSurrogate(s string) bool {
+	return len(s) >= 3 && s[0] == 0xed &&
+		s[1] >= 0xa0 && s[1] <= 0xbf && s[2] >= 0x80 && s[2] <= 0xbf
+}
+
+func decodeLatin1(b []byte) (string, []byte) {
+	var sb strings.Builder
+	for _, c := range b {
+		sb.WriteRune(rune(c))
+	}
+	return sb.String(), nil
+}
+
+// encodeLatin1 encodes s as Latin-1. Invalid UTF-8 in s is written as it
+// is, since it can only have come from a file that wasn't UTF-8 after all.
+func encodeLatin1(s string) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, s[i])
+		case r > 0xff:
+			return nil, fmt.Errorf("can't write %q as latin1", r)
+		default:
+			b = append(b, byte(r))
+		}
+		i += size
+	}
+	return b, nil
+}
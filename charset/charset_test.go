@@ -0,0 +1,122 @@
+package charset
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		e        *Encoding
+		contents []byte
+		text     string
+	}{
+		{UTF8, []byte("héllo"), "héllo"},
+		{UTF8, []byte("a\xffb\xc3"), "a\xffb\xc3"},
+		{UTF8BOM, []byte("\xef\xbb\xbfhé"), "hé"},
+		{Latin1, []byte("h\xe9llo\xff"), "hélloÿ"},
+		{UTF16LE, []byte("\xff\xfeh\x00\xe9\x00"), "hé"},
+		{UTF16BE, []byte("\xfe\xff\x00h\x00\xe9"), "hé"},
+		// A surrogate pair is one character.
+		{UTF16LE, []byte("\xff\xfe\x3d\xd8\x00\xde"), "😀"},
+		{UTF16BE, []byte("\xfe\xff\xd8\x3d\xde\x00"), "😀"},
+		// Unpaired surrogates are kept as they'd be in UTF-8.
+		{UTF16BE, []byte("\xfe\xff\xd8\x3d"), "\xed\xa0\xbd"},
+		{UTF16BE, []byte("\xfe\xff\xde\x00\x00a"), "\xed\xb8\x80a"},
+		{UTF16BE, []byte("\xfe\xff\xd8\x3d\x00a"), "\xed\xa0\xbda"},
+		{UTF16BE, []byte("\xfe\xff\xd8\x3d\xd8\x3d\xde\x00"),
+			"\xed\xa0\xbd😀"},
+		// An odd byte at the end is left over.
+		{UTF16LE, []byte("\xff\xfea\x00b"), "a"},
+	} {
+		text, rest := test.e.Decode(test.contents)
+		if text != test.text {
+			t.Errorf("%v: %q decodes as %q, want %q",
+				test.e.Name, test.contents, text, test.text)
+		}
+		contents, err := test.e.Encode(text, rest)
+		if err != nil || !bytes.Equal(contents, test.contents) {
+			t.Errorf("%v: %q encodes back as %q, %v",
+				test.e.Name, test.contents, contents, err)
+		}
+	}
+}
+
+// TestRandomRoundTrip checks that any bytes at all come back unchanged
+// from any encoding.
+func TestRandomRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		for _, e := range Encodings {
+			b := make([]byte, r.Intn(16))
+			for j := range b {
+				// Favour the bytes of surrogates, to pair them up often.
+				if r.Intn(2) == 0 {
+					b[j] = []byte{0xd8, 0xdc, 0xde, 0x3d, 0x00}[r.Intn(5)]
+				} else {
+					b[j] = byte(r.Intn(256))
+				}
+			}
+			contents := append(append([]byte{}, e.BOM...), b...)
+			text, rest := e.Decode(contents)
+			if e == Latin1 && !utf8.ValidString(text) {
+				t.Errorf("latin1: %q decodes as invalid UTF-8 %q",
+					contents, text)
+			}
+			got, err := e.Encode(text, rest)
+			if err != nil || !bytes.Equal(got, contents) {
+				t.Fatalf("%v: %q decodes as %q and encodes back as %q, %v",
+					e.Name, contents, text, got, err)
+			}
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		e    *Encoding
+		text string
+	}{
+		{Latin1, "€"},
+		{UTF16LE, "a\xffb"},
+		{UTF16BE, "\xed\xa0"},
+	} {
+		if b, err := test.e.Encode(test.text, nil); err == nil {
+			t.Errorf("%v: %q encodes as %q", test.e.Name, test.text, b)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, test := range []struct {
+		contents string
+		want     *Encoding
+	}{
+		{"", UTF8},
+		{"héllo", UTF8},
+		{"\xef\xbb\xbfhi", UTF8BOM},
+		{"\xff\xfeh\x00", UTF16LE},
+		{"\xfe\xff\x00h", UTF16BE},
+		{"h\xe9llo", Latin1},
+	} {
+		if got := Detect([]byte(test.contents)); got != test.want {
+			t.Errorf("%q detected as %v, want %v",
+				test.contents, got.Name, test.want.Name)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	for name, want := range map[string]*Encoding{
+		"UTF-8":      UTF8,
+		"utf16le":    UTF16LE,
+		"ISO-8859-1": Latin1,
+		"ebcdic":     nil,
+	} {
+		if got := Find(name); got != want {
+			t.Errorf("Find(%q) is %v, want %v", name, got, want)
+		}
+	}
+}
@@ -3,7 +3,7 @@ module github.com/callum-oakley/vee
 go 1.16
 
 require (
-	github.com/atotto/clipboard v0.1.4 // indirect
-	github.com/gdamore/tcell/v2 v2.3.8 // indirect
-	github.com/mattn/go-runewidth v0.0.10 // indirect
+	github.com/atotto/clipboard v0.1.4
+	github.com/gdamore/tcell/v2 v2.3.8
+	github.com/mattn/go-runewidth v0.0.10
 )
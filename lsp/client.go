@@ -0,0 +1,393 @@
+// Package lsp is a client for language servers speaking the Language Server
+// Protocol over stdio.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timeout is how long to wait for the server to respond to a request.
+var Timeout = 5 * time.Second
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *responseError) Error() string {
+	return e.Message
+}
+
+// A Client is a connection to a running language server.
+type Client struct {
+	cmd           *exec.Cmd
+	w             io.WriteCloser
+	onDiagnostics func(uri string, diagnostics []Diagnostic)
+	encoding      string
+	incremental   bool
+
+	mu      sync.Mutex // guards the fields below, and writes to w
+	nextID  int
+	pending map[int]chan message
+	err     error
+}
+
+// Start runs the server command in the directory root and initialises it.
+// onDiagnostics is called from another goroutine whenever the server
+// publishes diagnostics for a document.
+func Start(
+	command []string,
+	root string,
+	onDiagnostics func(uri string, diagnostics []Diagnostic),
+) (*Client, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no server command")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = root
+	cmd.Stderr = ioutil.Discard
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	c := &Client{
+		cmd:           cmd,
+		w:             w,
+		onDiagnostics: onDiagnostics,
+		pending:       map[int]chan message{},
+	}
+	go c.read(bufio.NewReader(r))
+
+	var result struct {
+		Capabilities struct {
+			PositionEncoding string          `json:"positionEncoding"`
+			TextDocumentSync json.RawMessage `json:"textDocumentSync"`
+		} `json:"capabilities"`
+	}
+	err = c.call("initialize", map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   URI(root),
+		"capabilities": map[string]interface{}{
+			"general": map[string]interface{}{
+				"positionEncodings": []string{"utf-8", "utf-16"},
+			},
+			"textDocument": map[string]interface{}{
+				"synchronization": map[string]interface{}{},
+				"hover": map[string]interface{}{
+					"contentFormat": []string{"plaintext", "markdown"},
+				},
+				"definition":         map[string]interface{}{},
+				"rename":             map[string]interface{}{},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+		},
+	}, &result)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initialize: %v", err)
+	}
+	c.encoding = result.Capabilities.PositionEncoding
+	c.incremental = syncKind(result.Capabilities.TextDocumentSync) == 2
+	if err := c.notify("initialized", struct{}{}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// syncKind extracts the kind of document sync from the textDocumentSync
+// capability, which is either a number or an object with a change field.
+func syncKind(sync json.RawMessage) int {
+	var kind int
+	if json.Unmarshal(sync, &kind) == nil {
+		return kind
+	}
+	var options struct {
+		Change int `json:"change"`
+	}
+	json.Unmarshal(sync, &options)
+	return options.Change
+}
+
+// Incremental reports whether the server accepts changes to part of a
+// document. If not, every change must replace the whole document.
+func (c *Client) Incremental() bool {
+	return c.incremental
+}
+
+// Close asks the server to shut down, and kills it if it doesn't.
+func (c *Client) Close() error {
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	c.w.Close()
+	done := make(chan error, 1)
+	go func() { done <- c.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(Timeout):
+		c.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+func (c *Client) DidOpen(
+	uri, languageID string,
+	version int,
+	text string,
+) error {
+	return c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        uri,
+			"languageId": languageID,
+			"version":    version,
+			"text":       text,
+		},
+	})
+}
+
+func (c *Client) DidChange(
+	uri string,
+	version int,
+	changes []ContentChange,
+) error {
+	return c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":     uri,
+			"version": version,
+		},
+		"contentChanges": changes,
+	})
+}
+
+func (c *Client) DidSave(uri string) error {
+	return c.notify("textDocument/didSave", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+	})
+}
+
+func (c *Client) DidClose(uri string) error {
+	return c.notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+	})
+}
+
+// Definition returns the locations where the symbol at pos is defined.
+func (c *Client) Definition(uri string, pos Position) ([]Location, error) {
+	var result json.RawMessage
+	err := c.call(
+		"textDocument/definition",
+		textDocumentPositionParams{textDocumentIdentifier{uri}, pos},
+		&result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return locations(result), nil
+}
+
+// Hover returns the documentation for the symbol at pos.
+func (c *Client) Hover(uri string, pos Position) (string, error) {
+	var result *struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	err := c.call(
+		"textDocument/hover",
+		textDocumentPositionParams{textDocumentIdentifier{uri}, pos},
+		&result,
+	)
+	if err != nil || result == nil {
+		return "", err
+	}
+	return hoverText(result.Contents), nil
+}
+
+// Rename returns the edits that rename the symbol at pos to newName.
+func (c *Client) Rename(
+	uri string,
+	pos Position,
+	newName string,
+) (*WorkspaceEdit, error) {
+	var result *WorkspaceEdit
+	err := c.call("textDocument/rename", map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+		"position":     pos,
+		"newName":      newName,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return &WorkspaceEdit{}, nil
+	}
+	return result, nil
+}
+
+func (c *Client) call(method string, params, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan message, 1)
+	c.pending[id] = ch
+	raw := json.RawMessage(strconv.Itoa(id))
+	err := c.write(&raw, method, params)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case m := <-ch:
+		if m.Error != nil {
+			return m.Error
+		}
+		if result == nil || m.Result == nil {
+			return nil
+		}
+		return json.Unmarshal(m.Result, result)
+	case <-time.After(Timeout):
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("%v: timed out", method)
+	}
+}
+
+func (c *Client) notify(method string, params interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.write(nil, method, params)
+}
+
+// write sends a request to the server, or a notification if id is nil.
+// c.mu must be held.
+func (c *Client) write(
+	id *json.RawMessage,
+	method string,
+	params interface{},
+) error {
+	m := message{ID: id, Method: method}
+	if params != nil {
+		p, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = p
+	}
+	return c.send(m)
+}
+
+// send writes m to the server. c.mu must be held.
+func (c *Client) send(m message) error {
+	m.JSONRPC = "2.0"
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.w, "Content-Length: %v\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// read handles messages from the server until it closes the connection.
+func (c *Client) read(r *bufio.Reader) {
+	for {
+		m, err := readMessage(r)
+		if err != nil {
+			c.mu.Lock()
+			c.err = fmt.Errorf("language server: %v", err)
+			for id, ch := range c.pending {
+				ch <- message{Error: &responseError{Message: c.err.Error()}}
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+		switch {
+		case m.ID != nil && m.Method != "":
+			c.reply(m)
+		case m.Method == "textDocument/publishDiagnostics":
+			var params publishDiagnosticsParams
+			err := json.Unmarshal(m.Params, &params)
+			if err == nil && c.onDiagnostics != nil {
+				c.onDiagnostics(params.URI, params.Diagnostics)
+			}
+		case m.ID != nil:
+			id, _ := strconv.Atoi(string(*m.ID))
+			c.mu.Lock()
+			if ch, ok := c.pending[id]; ok {
+				ch <- m
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// reply answers a request from the server. None are supported, so the reply
+// is as close to "no opinion" as each method allows.
+func (c *Client) reply(m message) {
+	var result interface{}
+	if m.Method == "workspace/configuration" {
+		var params struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(m.Params, &params)
+		result = make([]interface{}, len(params.Items))
+	}
+	body, _ := json.Marshal(result)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.send(message{ID: m.ID, Result: body})
+}
+
+func readMessage(r *bufio.Reader) (message, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return message{}, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return message{}, fmt.Errorf("bad Content-Length: %v", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return message{}, err
+	}
+	var m message
+	err = json.Unmarshal(body, &m)
+	return m, err
+}
@@ -0,0 +1,180 @@
+package lsp
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/callum-oakley/vee/lsp/lsptest"
+)
+
+// The test binary stands in for a language server when it's run with
+// serverEnv set.
+const serverEnv = "VEE_LSPTEST_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(serverEnv) != "" {
+		lsptest.Serve(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type published struct {
+	uri         string
+	diagnostics []Diagnostic
+}
+
+func start(t *testing.T) (*Client, chan published) {
+	t.Helper()
+	os.Setenv(serverEnv, "1")
+	defer os.Unsetenv(serverEnv)
+	ch := make(chan published, 16)
+	c, err := Start([]string{os.Args[0]}, t.TempDir(), func(
+		uri string,
+		diagnostics []Diagnostic,
+	) {
+		ch <- published{uri, diagnostics}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, ch
+}
+
+func next(t *testing.T, ch chan published) published {
+	t.Helper()
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(Timeout):
+		t.Fatal("no diagnostics published")
+		return published{}
+	}
+}
+
+func serverText(t *testing.T, c *Client, uri string) string {
+	t.Helper()
+	var text string
+	params := map[string]interface{}{
+		"textDocument": textDocumentIdentifier{uri},
+	}
+	if err := c.call("lsptest/text", params, &text); err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func TestDidOpen(t *testing.T) {
+	c, ch := start(t)
+	if !c.Incremental() {
+		t.Error("server should accept incremental changes")
+	}
+	uri := URI("a.go")
+	if err := c.DidOpen(uri, "go", 0, "one\nbad\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := serverText(t, c, uri); got != "one\nbad\n" {
+		t.Errorf("server has %q", got)
+	}
+	p := next(t, ch)
+	want := []Diagnostic{{
+		Range:    Range{Position{1, 0}, Position{1, 3}},
+		Severity: SeverityError,
+		Message:  "bad",
+	}}
+	if p.uri != uri || !reflect.DeepEqual(p.diagnostics, want) {
+		t.Errorf("got %v %+v, want %v %+v", p.uri, p.diagnostics, uri, want)
+	}
+}
+
+func TestDidChangeIncremental(t *testing.T) {
+	c, ch := start(t)
+	uri := URI("a.go")
+	c.DidOpen(uri, "go", 0, "one\ntwo\nthree\n")
+	next(t, ch)
+	for i, test := range []struct {
+		change ContentChange
+		want   string
+	}{
+		{
+			ContentChange{&Range{Position{1, 0}, Position{2, 0}}, "2\n"},
+			"one\n2\nthree\n",
+		},
+		{
+			ContentChange{&Range{Position{0, 0}, Position{0, 0}}, "zero\n"},
+			"zero\none\n2\nthree\n",
+		},
+		{
+			ContentChange{&Range{Position{1, 0}, Position{3, 0}}, ""},
+			"zero\nthree\n",
+		},
+		{
+			ContentChange{&Range{Position{2, 0}, Position{2, 0}}, "warn\n"},
+			"zero\nthree\nwarn\n",
+		},
+	} {
+		changes := []ContentChange{test.change}
+		if err := c.DidChange(uri, i+1, changes); err != nil {
+			t.Fatal(err)
+		}
+		if got := serverText(t, c, uri); got != test.want {
+			t.Errorf("change %v: server has %q, want %q", i, got, test.want)
+		}
+		next(t, ch)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c, ch := start(t)
+	uri := URI("a.go")
+	c.DidOpen(uri, "go", 0, "func f() {}\nfunc g() { f() }\n")
+	next(t, ch)
+	locations, err := c.Definition(uri, Position{1, 11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Location{{uri, Range{Position{0, 5}, Position{0, 5}}}}
+	if !reflect.DeepEqual(locations, want) {
+		t.Errorf("got %+v, want %+v", locations, want)
+	}
+	locations, err = c.Definition(uri, Position{0, 0})
+	if err != nil || len(locations) != 0 {
+		t.Errorf("got %+v, %v, want no locations", locations, err)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c, ch := start(t)
+	uri := URI("a.go")
+	c.DidOpen(uri, "go", 0, "func f() {}\n")
+	next(t, ch)
+	text, err := c.Hover(uri, Position{0, 5})
+	if err != nil || text != "about f" {
+		t.Errorf("got %q, %v, want %q", text, err, "about f")
+	}
+	text, err = c.Hover(uri, Position{0, 4})
+	if err != nil || text != "" {
+		t.Errorf("got %q, %v, want nothing", text, err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	c, ch := start(t)
+	uri := URI("a.go")
+	c.DidOpen(uri, "go", 0, "func f() {}\nfunc g() { f() }\n")
+	next(t, ch)
+	edit, err := c.Rename(uri, Position{0, 5}, "h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]TextEdit{uri: {
+		{Range{Position{0, 5}, Position{0, 6}}, "h"},
+		{Range{Position{1, 11}, Position{1, 12}}, "h"},
+	}}
+	if got := edit.Edits(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
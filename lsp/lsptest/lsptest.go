@@ -0,0 +1,291 @@
+// Package lsptest is a stand-in language server for testing clients. It
+// understands just enough of the protocol to exercise them: documents are
+// kept in sync, "bad" and "warn" are reported as an error and a warning
+// wherever they appear, the definition of a word is where it follows
+// "func ", hovering shows the word, and renaming replaces every occurrence
+// of the word in its document. Positions are taken to count bytes, which is
+// right for ASCII text in any encoding.
+package lsptest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   interface{}      `json:"error,omitempty"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type span struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type positionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position position `json:"position"`
+	NewName  string   `json:"newName"`
+}
+
+var word = regexp.MustCompile(`\w+`)
+
+// problems are the words reported wherever they appear, with the severity
+// of an error and of a warning.
+var problems = []struct {
+	text     string
+	severity int
+}{{"bad", 1}, {"warn", 2}}
+
+// A server holds the documents open in it, by URI.
+type server struct {
+	w         io.Writer
+	documents map[string]string
+}
+
+// Serve answers the messages read from r, writing to w, until it's told to
+// exit or r is closed.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{w: w, documents: map[string]string{}}
+	br := bufio.NewReader(r)
+	for {
+		m, err := read(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(m)
+		if m.ID == nil {
+			continue
+		}
+		reply := message{ID: m.ID, Result: result}
+		if err != nil {
+			reply.Error = map[string]interface{}{
+				"code":    -32601,
+				"message": err.Error(),
+			}
+		} else if result == nil {
+			reply.Result = json.RawMessage("null")
+		}
+		if err := s.send(reply); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(m message) (interface{}, error) {
+	switch m.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": 2,
+			},
+		}, nil
+	case "initialized", "shutdown", "textDocument/didSave":
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		json.Unmarshal(m.Params, &params)
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+		return nil, s.publish(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Range *span  `json:"range"`
+				Text  string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		json.Unmarshal(m.Params, &params)
+		uri := params.TextDocument.URI
+		for _, change := range params.ContentChanges {
+			if change.Range == nil {
+				s.documents[uri] = change.Text
+				continue
+			}
+			text := s.documents[uri]
+			start := offset(text, change.Range.Start)
+			end := offset(text, change.Range.End)
+			s.documents[uri] = text[:start] + change.Text + text[end:]
+		}
+		return nil, s.publish(uri)
+	case "textDocument/didClose":
+		var params positionParams
+		json.Unmarshal(m.Params, &params)
+		delete(s.documents, params.TextDocument.URI)
+		return nil, nil
+	case "lsptest/text":
+		var params positionParams
+		json.Unmarshal(m.Params, &params)
+		return s.documents[params.TextDocument.URI], nil
+	case "textDocument/definition":
+		var params positionParams
+		json.Unmarshal(m.Params, &params)
+		uri := params.TextDocument.URI
+		w := s.wordAt(uri, params.Position)
+		lines := strings.Split(s.documents[uri], "\n")
+		for y, line := range lines {
+			if i := strings.Index(line, "func "+w+"("); w != "" && i >= 0 {
+				at := position{y, i + len("func ")}
+				return map[string]interface{}{
+					"uri":   uri,
+					"range": span{at, at},
+				}, nil
+			}
+		}
+		return []interface{}{}, nil
+	case "textDocument/hover":
+		var params positionParams
+		json.Unmarshal(m.Params, &params)
+		w := s.wordAt(params.TextDocument.URI, params.Position)
+		if w == "" {
+			return nil, nil
+		}
+		return map[string]interface{}{
+			"contents": map[string]string{
+				"kind":  "plaintext",
+				"value": "about " + w,
+			},
+		}, nil
+	case "textDocument/rename":
+		var params positionParams
+		json.Unmarshal(m.Params, &params)
+		uri := params.TextDocument.URI
+		w := s.wordAt(uri, params.Position)
+		var edits []interface{}
+		for y, line := range strings.Split(s.documents[uri], "\n") {
+			for _, loc := range word.FindAllStringIndex(line, -1) {
+				if line[loc[0]:loc[1]] == w {
+					start, end := position{y, loc[0]}, position{y, loc[1]}
+					edits = append(edits, map[string]interface{}{
+						"range":   span{start, end},
+						"newText": params.NewName,
+					})
+				}
+			}
+		}
+		return map[string]interface{}{
+			"changes": map[string]interface{}{uri: edits},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown method %q", m.Method)
+}
+
+// wordAt returns the word at pos in the document at uri, if there is one.
+func (s *server) wordAt(uri string, pos position) string {
+	lines := strings.Split(s.documents[uri], "\n")
+	if pos.Line >= len(lines) {
+		return ""
+	}
+	line := lines[pos.Line]
+	for _, loc := range word.FindAllStringIndex(line, -1) {
+		if loc[0] <= pos.Character && pos.Character < loc[1] {
+			return line[loc[0]:loc[1]]
+		}
+	}
+	return ""
+}
+
+// publish reports the problems in the document at uri.
+func (s *server) publish(uri string) error {
+	diagnostics := []interface{}{}
+	for y, line := range strings.Split(s.documents[uri], "\n") {
+		for _, p := range problems {
+			if x := strings.Index(line, p.text); x >= 0 {
+				end := position{y, x + len(p.text)}
+				diagnostics = append(diagnostics, map[string]interface{}{
+					"range":    span{position{y, x}, end},
+					"severity": p.severity,
+					"message":  p.text,
+				})
+			}
+		}
+	}
+	params, err := json.Marshal(map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+	if err != nil {
+		return err
+	}
+	return s.send(message{
+		Method: "textDocument/publishDiagnostics",
+		Params: params,
+	})
+}
+
+// offset converts pos to a byte offset in text.
+func offset(text string, pos position) int {
+	i := 0
+	for y := 0; y < pos.Line; y++ {
+		next := strings.IndexByte(text[i:], '\n')
+		if next < 0 {
+			return len(text)
+		}
+		i += next + 1
+	}
+	end := strings.IndexByte(text[i:], '\n')
+	if end < 0 {
+		end = len(text) - i
+	}
+	if pos.Character < end {
+		return i + pos.Character
+	}
+	return i + end
+}
+
+func (s *server) send(m message) error {
+	m.JSONRPC = "2.0"
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %v\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func read(r *bufio.Reader) (message, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return message{}, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return message{}, fmt.Errorf("bad Content-Length: %v", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return message{}, err
+	}
+	var m message
+	err = json.Unmarshal(body, &m)
+	return m, err
+}
@@ -0,0 +1,205 @@
+package lsp
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type DiagnosticSeverity int
+
+const (
+	SeverityError DiagnosticSeverity = iota + 1
+	SeverityWarning
+	SeverityInformation
+	SeverityHint
+)
+
+type Diagnostic struct {
+	Range    Range              `json:"range"`
+	Severity DiagnosticSeverity `json:"severity,omitempty"`
+	Source   string             `json:"source,omitempty"`
+	Message  string             `json:"message"`
+}
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits []TextEdit `json:"edits"`
+	} `json:"documentChanges,omitempty"`
+}
+
+// Edits returns the edits to each file, keyed by URI, whichever way the
+// server chose to express them.
+func (e *WorkspaceEdit) Edits() map[string][]TextEdit {
+	edits := map[string][]TextEdit{}
+	for uri, es := range e.Changes {
+		edits[uri] = append(edits[uri], es...)
+	}
+	for _, dc := range e.DocumentChanges {
+		uri := dc.TextDocument.URI
+		edits[uri] = append(edits[uri], dc.Edits...)
+	}
+	return edits
+}
+
+// A ContentChange replaces Range with Text, or the whole document if Range
+// is nil.
+type ContentChange struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// hoverText extracts the text from the contents of a hover response, which
+// may be MarkupContent, a MarkedString, or a list of MarkedStrings.
+func hoverText(contents json.RawMessage) string {
+	var s string
+	if json.Unmarshal(contents, &s) == nil {
+		return s
+	}
+	var mc markupContent
+	if json.Unmarshal(contents, &mc) == nil && mc.Value != "" {
+		return mc.Value
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			parts = append(parts, hoverText(item))
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	return ""
+}
+
+// locations extracts the locations from a definition response, which may be
+// a Location, a list of Locations, or a list of LocationLinks.
+func locations(result json.RawMessage) []Location {
+	var loc Location
+	if json.Unmarshal(result, &loc) == nil && loc.URI != "" {
+		return []Location{loc}
+	}
+	var list []struct {
+		Location
+		TargetURI            string `json:"targetUri"`
+		TargetSelectionRange Range  `json:"targetSelectionRange"`
+	}
+	if json.Unmarshal(result, &list) != nil {
+		return nil
+	}
+	var locs []Location
+	for _, l := range list {
+		if l.TargetURI != "" {
+			locs = append(locs, Location{l.TargetURI, l.TargetSelectionRange})
+		} else {
+			locs = append(locs, l.Location)
+		}
+	}
+	return locs
+}
+
+// URI returns the file URI of path.
+func URI(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+}
+
+// Path returns the path of a file URI.
+func Path(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// Character converts byte offset x in line to a character offset in the
+// client's position encoding.
+func (c *Client) Character(line string, x int) int {
+	x = min(max(0, x), len(line))
+	if c.encoding == "utf-8" {
+		return x
+	}
+	n := 0
+	for _, r := range line[:x] {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// Offset converts character offset character in line, in the client's
+// position encoding, to a byte offset.
+func (c *Client) Offset(line string, character int) int {
+	if c.encoding == "utf-8" {
+		return min(max(0, character), len(line))
+	}
+	n := 0
+	for x, r := range line {
+		if n >= character {
+			return x
+		}
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return len(line)
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
-	"io/ioutil"
+	"flag"
+	"fmt"
 	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
 	"strings"
 
 	"github.com/callum-oakley/vee/state"
@@ -10,31 +14,158 @@ import (
 	"github.com/gdamore/tcell/v2"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "devel"
+
+const usage = `usage: vee [flags] [+line] [file[:line[:col]]]...
+
+Opens each file in a buffer of its own, creating files that don't exist yet
+when they're first saved. A position can follow the file name, as in
+compiler errors, or come before it as +line.
+
+flags:
+`
+
+// An arg is a file to open, and where to put the cursor in it.
+type arg struct {
+	path      string
+	line, col int
+}
+
+// positionSuffix matches the :line or :line:col at the end of a path, with
+// an optional trailing colon.
+var positionSuffix = regexp.MustCompile(`^(.+?):(\d+)(?::(\d+))?:?$`)
+
+// parseArgs pairs each path with its position. A path ending in a position
+// is only split if there's no file with the whole path as its name, and if
+// there's one named with the path and line, the column is its line.
+func parseArgs(args []string) ([]arg, error) {
+	var files []arg
+	line, lineArg := 0, ""
+	for _, a := range args {
+		if len(a) > 1 && a[0] == '+' {
+			n, err := strconv.Atoi(a[1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid line %q", a)
+			}
+			line, lineArg = n, a
+			continue
+		}
+		f := arg{path: a, line: line, col: 1}
+		line, lineArg = 0, ""
+		if _, err := os.Stat(a); os.IsNotExist(err) {
+			if m := positionSuffix.FindStringSubmatch(a); m != nil {
+				f.path = m[1]
+				f.line, _ = strconv.Atoi(m[2])
+				if m[3] != "" {
+					f.col, _ = strconv.Atoi(m[3])
+				}
+				withLine := m[1] + ":" + m[2]
+				if _, err := os.Stat(withLine); m[3] != "" && err == nil {
+					f.path, f.line, f.col = withLine, f.col, 1
+				}
+			}
+		}
+		files = append(files, f)
+	}
+	if lineArg != "" {
+		return nil, fmt.Errorf("%v isn't followed by a file", lineArg)
+	}
+	return files, nil
+}
+
 func main() {
-	text, err := ioutil.ReadFile(os.Args[2])
-	if err != nil {
-		panic(err)
+	defaultConfig, _ := state.ConfigPath()
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	tabWidth := flag.Int("tabwidth", 0, "display tabs `n` columns wide")
+	readOnly := flag.Bool("readonly", false, "only write files with write!")
+	config := flag.String(
+		"config", defaultConfig, "load the config file at `path`, if any",
+	)
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
 	}
-	lines := strings.Split(string(text), "\n")
-	if lines[len(lines)-1] == "" {
-		lines = lines[:len(lines)-1]
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("vee", version)
+		return
 	}
-	s := state.State{
-		FilePath: os.Args[2],
-		TabWidth: 4,
-		Text:     lines,
+	if *tabWidth < 0 {
+		fmt.Fprintln(os.Stderr, "vee: invalid tab width", *tabWidth)
+		os.Exit(2)
+	}
+	files, err := parseArgs(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vee:", err)
+		flag.Usage()
+		os.Exit(2)
 	}
 
 	screen, err := tcell.NewScreen()
-	if err != nil {
-		panic(err)
+	if err == nil {
+		err = screen.Init()
 	}
-	if err := screen.Init(); err != nil {
-		panic(err)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vee:", err)
+		os.Exit(1)
 	}
 	defer screen.Fini()
 
-	r := ui.Renderer{S: &s, Screen: screen}
+	s := &state.State{TabWidth: 4, Terminal: os.Stdout}
+	defer rescue(screen, s)
+	s.Interrupt = func(f func()) {
+		screen.PostEvent(tcell.NewEventInterrupt(f))
+	}
+	defer s.CloseLanguageServers()
+	if *config != "" {
+		s.LoadConfig(*config)
+	}
+	configMsg := s.Msg
+	if *tabWidth > 0 {
+		s.TabWidth = *tabWidth
+	}
+	if *readOnly {
+		s.ReadOnly = true
+	}
+	if len(files) == 0 {
+		files = []arg{{}}
+	}
+	var errs []error
+	for _, f := range files {
+		if err := s.Open(f.path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if f.line > 0 {
+			s.GoTo(f.line, f.col)
+		}
+	}
+	if len(s.Buffers) == 0 {
+		screen.Fini()
+		if configMsg != "" {
+			fmt.Fprintln(os.Stderr, "vee:", configMsg)
+		}
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, "vee:", err)
+		}
+		os.Exit(1)
+	}
+	// Errors from the config file are shown along with any from opening the
+	// files, rather than replaced by them.
+	var msgs []string
+	for _, msg := range []string{configMsg, s.Msg} {
+		if msg != "" && (len(msgs) == 0 || msgs[0] != msg) {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(errs) > 0 {
+		msgs = append(msgs, errs[0].Error())
+	}
+	s.Msg = strings.Join(msgs, "; ")
+	s.SwitchBuffer(0)
+
+	r := ui.Renderer{S: s, Screen: screen}
 	r.Render()
 
 	for {
@@ -46,6 +177,26 @@ func main() {
 				return
 			}
 			r.Render()
+		case *tcell.EventInterrupt:
+			if f, ok := e.Data().(func()); ok {
+				f()
+			}
+			r.Render()
 		}
 	}
 }
+
+// rescue recovers from a panic by restoring the terminal and writing out
+// any unsaved changes before exiting.
+func rescue(screen tcell.Screen, s *state.State) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	screen.Fini()
+	fmt.Fprintf(os.Stderr, "vee: %v\n%s", r, debug.Stack())
+	for _, line := range s.Rescue() {
+		fmt.Fprintln(os.Stderr, "vee:", line)
+	}
+	os.Exit(2)
+}
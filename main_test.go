@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	// A file whose name looks like it ends in a position.
+	if err := ioutil.WriteFile("a:1", nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		args []string
+		want []arg
+	}{
+		{nil, nil},
+		{[]string{"a"}, []arg{{"a", 0, 1}}},
+		{[]string{"+3", "a", "b"}, []arg{{"a", 3, 1}, {"b", 0, 1}}},
+		{[]string{"a", "+3", "b"}, []arg{{"a", 0, 1}, {"b", 3, 1}}},
+		{[]string{"a:3"}, []arg{{"a", 3, 1}}},
+		{[]string{"a:3:4"}, []arg{{"a", 3, 4}}},
+		{[]string{"a:3:4:"}, []arg{{"a", 3, 4}}},
+		{[]string{"dir/a.go:10:2"}, []arg{{"dir/a.go", 10, 2}}},
+		{[]string{"a:1"}, []arg{{"a:1", 0, 1}}},
+		{[]string{"a:1:2"}, []arg{{"a:1", 2, 1}}},
+		{[]string{"a:x"}, []arg{{"a:x", 0, 1}}},
+		{[]string{"+"}, []arg{{"+", 0, 1}}},
+	} {
+		got, err := parseArgs(test.args)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, %v, want %+v",
+				test.args, got, err, test.want)
+		}
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"+x", "a"},
+		{"+3"},
+		{"a", "+3"},
+	} {
+		if got, err := parseArgs(args); err == nil {
+			t.Errorf("%q: got %+v, want an error", args, got)
+		}
+	}
+}
@@ -11,11 +11,7 @@ func (s *State) newLineAbove() {
 }
 
 func (s *State) normalisedSelection() (cursor, cursor) {
-	if s.Cursor.Y < s.Anchor.Y ||
-		s.Cursor.Y == s.Anchor.Y && s.Cursor.X < s.Anchor.X {
-		return s.Cursor, s.Anchor
-	}
-	return s.Anchor, s.Cursor
+	return Selection{s.Anchor, s.Cursor}.normalised()
 }
 
 func (s *State) normaliseSelection() {
@@ -28,16 +24,16 @@ func (s *State) delete() {
 	if s.Anchor.X == -1 && s.Cursor.X == -1 {
 		after = []string{}
 	} else if s.Anchor.X == -1 {
-		after = []string{s.Text[s.Cursor.Y][s.xRightOf(&s.Cursor):]}
+		after = []string{s.Text.Line(s.Cursor.Y)[s.xRightOf(&s.Cursor):]}
 	} else if s.Cursor.X == -1 {
-		after = []string{s.Text[s.Anchor.Y][:s.Anchor.X]}
+		after = []string{s.Text.Line(s.Anchor.Y)[:s.Anchor.X]}
 	} else {
-		after = []string{s.Text[s.Anchor.Y][:s.Anchor.X] +
-			s.Text[s.Cursor.Y][s.xRightOf(&s.Cursor):]}
+		after = []string{s.Text.Line(s.Anchor.Y)[:s.Anchor.X] +
+			s.Text.Line(s.Cursor.Y)[s.xRightOf(&s.Cursor):]}
 	}
 	s.applyDiff(diff{
 		start:  s.Anchor.Y,
-		before: s.Text[s.Anchor.Y : s.Cursor.Y+1],
+		before: s.Text.Lines(s.Anchor.Y, s.Cursor.Y+1),
 		after:  after,
 	})
 	s.setCursorY(&s.Anchor, s.Anchor.Y)
@@ -46,13 +42,18 @@ func (s *State) delete() {
 
 func (s *State) deleteLines() {
 	s.normaliseSelection()
+	after := []string{}
+	if s.Anchor.Y == 0 && s.Cursor.Y == s.Text.Len()-1 {
+		// There's always a line, even once they've all been deleted.
+		after = []string{""}
+	}
 	s.applyDiff(diff{
 		start:  s.Anchor.Y,
-		before: s.Text[s.Anchor.Y : s.Cursor.Y+1],
-		after:  []string{},
+		before: s.Text.Lines(s.Anchor.Y, s.Cursor.Y+1),
+		after:  after,
 	})
-	if s.Anchor.Y >= len(s.Text) {
-		s.Anchor.Y = len(s.Text) - 1
+	if s.Anchor.Y >= s.Text.Len() {
+		s.Anchor.Y = s.Text.Len() - 1
 	}
 	s.setCursorY(&s.Anchor, s.Anchor.Y)
 	s.Cursor = s.Anchor
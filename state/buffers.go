@@ -0,0 +1,65 @@
+package state
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/callum-oakley/vee/syntax"
+)
+
+func (s *State) findBuffer(path string) int {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return -1
+	}
+	for i, b := range s.Buffers {
+		if bAbs, err := filepath.Abs(b.FilePath); err == nil && bAbs == abs {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *State) bufferIndex() int {
+	for i, b := range s.Buffers {
+		if b == s.Buffer {
+			return i
+		}
+	}
+	return -1
+}
+
+// SwitchBuffer makes the buffer at index i in s.Buffers the current buffer.
+func (s *State) SwitchBuffer(i int) {
+	s.Buffer = s.Buffers[i]
+}
+
+func (s *State) cycleBuffers(n int) {
+	i := s.bufferIndex() + n
+	s.SwitchBuffer((i%len(s.Buffers) + len(s.Buffers)) % len(s.Buffers))
+}
+
+func (s *State) listBuffers() {
+	var items []string
+	for i, b := range s.Buffers {
+		items = append(items, fmt.Sprintf("%v %v", i+1, b.FilePath))
+	}
+	s.startMenu(items, s.bufferIndex(), s.SwitchBuffer)
+}
+
+// BufferStatus describes the current buffer's position in the buffer list,
+// or is empty if there is only one buffer.
+func (s *State) BufferStatus() string {
+	if len(s.Buffers) < 2 {
+		return ""
+	}
+	return fmt.Sprintf("[%v/%v]", s.bufferIndex()+1, len(s.Buffers))
+}
+
+func (b *Buffer) setLanguage(lang *syntax.Language) {
+	if lang == nil {
+		b.Highlighter = nil
+	} else {
+		b.Highlighter = syntax.NewHighlighter(lang)
+	}
+}
@@ -1,44 +1,232 @@
 package state
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
 	"strings"
 
 	"github.com/atotto/clipboard"
 )
 
+// copy copies the text of each selection to the selected register.
 func (s *State) copy() {
+	var selectedTexts []string
+	s.forEachSelection(func() {
+		selectedTexts = append(
+			[]string{s.selectedText()},
+			selectedTexts...,
+		)
+	})
+	s.report(s.writeRegister(s.takeRegister(), selectedTexts))
+}
+
+// pasteAll pastes from the selected register after every selection. If the
+// register holds a text for each selection, each selection gets its own,
+// otherwise each gets all of them, one per line.
+func (s *State) pasteAll() {
+	texts, err := s.readRegister(s.takeRegister())
+	if err != nil {
+		s.report(err)
+		return
+	}
+	n := len(s.Selections())
+	i := n
+	s.startChange()
+	s.forEachSelection(func() {
+		i--
+		if len(texts) == n {
+			s.paste(texts[i])
+		} else {
+			s.paste(strings.Join(texts, "\n"))
+		}
+	})
+	s.endChange()
+}
+
+func (s *State) selectedText() string {
 	from, to := s.normalisedSelection()
 	selectedText := ""
 	for y := from.Y; y <= to.Y; y++ {
 		if y == from.Y && from.X == -1 || y == to.Y && to.X == -1 {
 			selectedText += "\n"
 		} else if y == from.Y && y == to.Y {
-			selectedText += s.Text[y][from.X:s.xRightOf(&to)]
+			selectedText += s.Text.Line(y)[from.X:s.xRightOf(&to)]
 		} else if y == from.Y {
-			selectedText += s.Text[y][from.X:] + "\n"
+			selectedText += s.Text.Line(y)[from.X:] + "\n"
 		} else if y == to.Y {
-			selectedText += s.Text[y][:s.xRightOf(&to)]
+			selectedText += s.Text.Line(y)[:s.xRightOf(&to)]
 		} else {
-			selectedText += s.Text[y] + "\n"
+			selectedText += s.Text.Line(y) + "\n"
 		}
 	}
-	if err := clipboard.WriteAll(selectedText); err != nil {
-		panic(err)
-	}
+	return selectedText
 }
 
-func (s *State) paste() {
+func (s *State) paste(text string) {
 	_, to := s.normalisedSelection()
-	text, err := clipboard.ReadAll()
-	if err != nil {
-		panic(err)
-	}
 	after := strings.Split(text, "\n")
-	after[0] = s.Text[to.Y][:s.xRightOf(&to)] + after[0]
-	after[len(after)-1] += s.Text[to.Y][s.xRightOf(&to):]
+	after[0] = s.Text.Line(to.Y)[:s.xRightOf(&to)] + after[0]
+	after[len(after)-1] += s.Text.Line(to.Y)[s.xRightOf(&to):]
 	s.applyDiff(diff{
 		start:  s.Cursor.Y,
-		before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
+		before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
 		after:  after,
 	})
 }
+
+// A clipboardBackend is a way of reading and writing the system clipboard.
+// Backends which can't read the clipboard have a nil read, and read back
+// whatever was last written instead.
+type clipboardBackend struct {
+	read  func(s *State) (string, error)
+	write func(s *State, text string) error
+}
+
+var clipboardBackends = map[string]clipboardBackend{
+	"system": {
+		read: func(*State) (string, error) {
+			return clipboard.ReadAll()
+		},
+		write: func(_ *State, text string) error {
+			return clipboard.WriteAll(text)
+		},
+	},
+	"osc52": {write: writeOSC52},
+	"command": {
+		read: func(s *State) (string, error) {
+			return runClipboardCommand(s.clipboardCommands["paste"], "")
+		},
+		write: func(s *State, text string) error {
+			_, err := runClipboardCommand(s.clipboardCommands["copy"], text)
+			return err
+		},
+	},
+	"memory": {write: func(*State, string) error { return nil }},
+}
+
+func init() {
+	RegisterCommand("clipboard-command", cmdClipboardCommand)
+	registerOption(
+		"clipboard",
+		func(s *State) string {
+			if s.clipboardName == "" {
+				return "auto (" + s.clipboardBackendName() + ")"
+			}
+			return s.clipboardName
+		},
+		func(s *State, value string) error {
+			if value == "auto" {
+				s.clipboardName = ""
+				return nil
+			}
+			if _, ok := clipboardBackends[value]; !ok {
+				return fmt.Errorf("unknown clipboard %q", value)
+			}
+			s.clipboardName = value
+			return nil
+		},
+	)
+}
+
+// clipboardBackendName returns the name of the clipboard backend in use.
+// Unless one has been chosen, it's the first of these that's available:
+// configured commands, OSC 52 over SSH, the system clipboard tools, OSC 52
+// anyway, and finally a clipboard internal to vee.
+func (s *State) clipboardBackendName() string {
+	switch {
+	case s.clipboardName != "":
+		return s.clipboardName
+	case len(s.clipboardCommands) > 0:
+		return "command"
+	case s.Terminal != nil && os.Getenv("SSH_TTY") != "":
+		return "osc52"
+	case !clipboard.Unsupported:
+		return "system"
+	case s.Terminal != nil:
+		return "osc52"
+	default:
+		return "memory"
+	}
+}
+
+// readClipboard reads the system clipboard. If it still holds what
+// writeClipboard last put there, the texts are split back out again.
+func (s *State) readClipboard() ([]string, error) {
+	backend := clipboardBackends[s.clipboardBackendName()]
+	if backend.read == nil {
+		if s.clipboard == nil {
+			return nil, errors.New("clipboard is empty")
+		}
+		return s.clipboard, nil
+	}
+	text, err := backend.read(s)
+	if err != nil {
+		return nil, fmt.Errorf("clipboard: %v", err)
+	}
+	if s.clipboard != nil && strings.Join(s.clipboard, "\n") == text {
+		return s.clipboard, nil
+	}
+	return []string{text}, nil
+}
+
+// writeClipboard writes texts to the system clipboard, one per line.
+func (s *State) writeClipboard(texts []string) error {
+	backend := clipboardBackends[s.clipboardBackendName()]
+	if err := backend.write(s, strings.Join(texts, "\n")); err != nil {
+		return fmt.Errorf("clipboard: %v", err)
+	}
+	s.clipboard = texts
+	return nil
+}
+
+// writeOSC52 asks the terminal to set the clipboard, which works even when
+// the terminal is on the other end of an SSH connection.
+func writeOSC52(s *State, text string) error {
+	if s.Terminal == nil {
+		return errors.New("no terminal")
+	}
+	_, err := fmt.Fprintf(
+		s.Terminal,
+		"\x1b]52;c;%v\a",
+		base64.StdEncoding.EncodeToString([]byte(text)),
+	)
+	return err
+}
+
+// runClipboardCommand runs command with input on stdin, and returns what it
+// writes to stdout.
+func runClipboardCommand(command []string, input string) (string, error) {
+	if len(command) == 0 {
+		return "", errors.New("no command; see clipboard-command")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", fmt.Errorf("%v: %v", command[0], err)
+	}
+	return string(out), nil
+}
+
+// cmdClipboardCommand sets the command the command clipboard backend runs to
+// copy or paste. Copy commands get the text on stdin, and paste commands
+// write it to stdout.
+func cmdClipboardCommand(s *State, args []string) error {
+	if len(args) < 2 || args[0] != "copy" && args[0] != "paste" {
+		return errors.New("usage: clipboard-command copy|paste command...")
+	}
+	if s.clipboardCommands == nil {
+		s.clipboardCommands = map[string][]string{}
+	}
+	s.clipboardCommands[args[0]] = args[1:]
+	return nil
+}
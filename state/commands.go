@@ -0,0 +1,208 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Command is run from the command line with the words following its name
+// as arguments.
+type Command func(s *State, args []string) error
+
+var commands = map[string]Command{}
+
+// RegisterCommand makes c available on the command line as name.
+func RegisterCommand(name string, c Command) {
+	commands[name] = c
+}
+
+func init() {
+	RegisterCommand("write", cmdWrite)
+	RegisterCommand("write!", cmdForceWrite)
+	RegisterCommand("edit", cmdEdit)
+	RegisterCommand("goto", cmdGoto)
+	RegisterCommand("set", cmdSet)
+	RegisterCommand("quit", cmdQuit)
+	RegisterCommand("quit!", cmdForceQuit)
+}
+
+func (s *State) startCommand() {
+	s.startPrompt(":", func(line string) {
+		s.report(s.runCommand(line))
+	})
+	s.prompt.complete = completeCommand
+}
+
+// report shows err on the message line, if it isn't nil.
+func (s *State) report(err error) {
+	if err != nil {
+		s.Msg = err.Error()
+	}
+}
+
+func (s *State) runCommand(line string) error {
+	words, err := splitWords(line)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	name, err := resolveCommand(words[0])
+	if err != nil {
+		return err
+	}
+	return commands[name](s, words[1:])
+}
+
+// resolveCommand returns the command called name, or failing that the only
+// command whose name starts with name.
+func resolveCommand(name string) (string, error) {
+	if _, ok := commands[name]; ok {
+		return name, nil
+	}
+	candidates := commandsWithPrefix(name)
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("unknown command %q", name)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf(
+			"ambiguous command %q: %v",
+			name, strings.Join(candidates, ", "),
+		)
+	}
+}
+
+func commandsWithPrefix(prefix string) []string {
+	var names []string
+	for name := range commands {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeCommand returns the possible completions of a partially typed
+// command line. Only command names are completed.
+func completeCommand(line string) []string {
+	if strings.ContainsAny(line, " \t") {
+		return nil
+	}
+	return commandsWithPrefix(line)
+}
+
+// splitWords splits line on whitespace, except within double quotes. A
+// backslash escapes the character following it.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+	for _, char := range line {
+		switch {
+		case escaped:
+			word.WriteRune(char)
+			escaped = false
+		case char == '\\':
+			inWord, escaped = true, true
+		case char == '"':
+			inWord, quoted = true, !quoted
+		case !quoted && (char == ' ' || char == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quoteWords quotes words so that splitWords splits them back out again.
+func quoteWords(words []string) []string {
+	var quoted []string
+	for _, word := range words {
+		if word != "" && !strings.ContainsAny(word, " \t\"\\") {
+			quoted = append(quoted, word)
+			continue
+		}
+		word = strings.ReplaceAll(word, `\`, `\\`)
+		quoted = append(quoted, `"`+strings.ReplaceAll(word, `"`, `\"`)+`"`)
+	}
+	return quoted
+}
+
+func cmdWrite(s *State, args []string) error {
+	return write(s, args, false)
+}
+
+func cmdForceWrite(s *State, args []string) error {
+	return write(s, args, true)
+}
+
+// write saves the current buffer, to a new path if one is given.
+func write(s *State, args []string, force bool) error {
+	switch len(args) {
+	case 0:
+		return s.save(force)
+	case 1:
+		return s.saveAs(args[0], force)
+	default:
+		return errors.New("usage: write [path]")
+	}
+}
+
+func cmdEdit(s *State, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: edit path")
+	}
+	return s.Open(args[0])
+}
+
+func cmdGoto(s *State, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: goto line")
+	}
+	y, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	s.keepPrimarySelection()
+	s.move(func(c *cursor) {
+		s.setCursorY(c, max(0, min(y-1, s.Text.Len()-1)))
+	})
+	return nil
+}
+
+func cmdQuit(s *State, args []string) error {
+	for _, b := range s.Buffers {
+		if b.Modified() {
+			return fmt.Errorf(
+				"%v has unsaved changes (use quit! to discard them)",
+				b.FilePath,
+			)
+		}
+	}
+	s.quit = true
+	return nil
+}
+
+func cmdForceQuit(s *State, args []string) error {
+	s.quit = true
+	return nil
+}
@@ -0,0 +1,54 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigPath returns the path of the user's config file.
+func ConfigPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "vee", "config"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "vee", "config"), nil
+}
+
+// LoadConfig runs each line of the config file at path as a command. Blank
+// lines and lines starting with # are skipped. An error doesn't stop later
+// lines from running, and is reported on the message line along with the
+// line it came from. A missing config file isn't an error.
+func (s *State) LoadConfig(path string) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		s.Msg = "config: " + err.Error()
+		return
+	}
+	defer f.Close()
+	var errs []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.runCommand(line); err != nil {
+			errs = append(errs, fmt.Sprintf("config:%v: %v", n, err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, "config: "+err.Error())
+	}
+	if len(errs) > 0 {
+		s.Msg = strings.Join(errs, "; ")
+	}
+}
@@ -10,7 +10,9 @@ import (
 
 func (s *State) debugUnicode() {
 	if s.Cursor.X >= 0 {
-		char, size := utf8.DecodeRuneInString(s.Text[s.Cursor.Y][s.Cursor.X:])
+		char, size := utf8.DecodeRuneInString(
+			s.Text.Line(s.Cursor.Y)[s.Cursor.X:],
+		)
 		s.Msg = fmt.Sprintf(
 			"%#U %v %v",
 			char, size, rw.RuneWidth(char),
@@ -24,13 +26,14 @@ func (s *State) debugCursor() {
 	s.Msg = fmt.Sprintf("a:%+v c:%+v", s.Anchor, s.Cursor)
 }
 
-func (s *State) debugLog(msg string) {
+func (s *State) debugLog(msg string) error {
 	f, err := os.OpenFile("vee.log", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	defer f.Close()
 	if _, err := f.WriteString(fmt.Sprintf("%v\n", msg)); err != nil {
-		panic(err)
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
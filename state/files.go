@@ -1,17 +1,225 @@
 package state
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+
+	"github.com/callum-oakley/vee/lsp"
+	"github.com/callum-oakley/vee/syntax"
+	"github.com/callum-oakley/vee/text"
 )
 
-func (s *State) save() {
-	f, err := os.Create(s.FilePath)
+// Open reads the file at path, along with its undo history if it has one,
+// into a new buffer and makes it the current buffer. If the file is already
+// open, Open switches to its buffer instead. If the file doesn't exist, the
+// buffer starts empty, and the file is created when it's saved. An empty
+// path makes a buffer with no file at all.
+func (s *State) Open(path string) error {
+	if i := s.findBuffer(path); i >= 0 {
+		s.SwitchBuffer(i)
+		return nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines, f := decode(contents, nil)
+	b := &Buffer{
+		FilePath:    path,
+		Text:        text.New(lines),
+		format:      f,
+		savedFormat: f,
+		Wrap:        s.DefaultWrap,
+	}
+	b.stampFile(contents)
+	firstLine := ""
+	if len(lines) > 0 {
+		firstLine = lines[0]
+	}
+	b.setLanguage(syntax.Detect(path, firstLine))
+	b.onChange = append(b.onChange, func(d diff) {
+		if b.Highlighter != nil {
+			b.Highlighter.Edit(d.start, len(d.before), len(d.after))
+		}
+		s.shiftWindows(b, d)
+		b.didChange(d)
+	})
+	s.Buffers = append(s.Buffers, b)
+	s.SwitchBuffer(len(s.Buffers) - 1)
+	if err := s.loadHistory(contents); err != nil {
+		s.Msg = "couldn't load undo history: " + err.Error()
+	}
+	s.initHistory()
+	s.saved = s.historyHead
+	s.attachLanguageServer(b)
+	s.watchFiles()
+	return nil
+}
+
+// GoTo moves the cursor to a line and column, counting from 1, or as near
+// as it can get. Columns count bytes, as compilers report them.
+func (s *State) GoTo(line, col int) {
+	s.jumpTo(cursor{X: col - 1, Y: line - 1})
+}
+
+// saveAs writes the current buffer to path, which becomes its file. Unless
+// force is set, it refuses to overwrite a file that's already there.
+func (s *State) saveAs(path string, force bool) error {
+	b := s.Buffer
+	if i := s.findBuffer(path); i >= 0 && s.Buffers[i] == b {
+		return s.save(force)
+	}
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf(
+				"%v already exists (use write! to overwrite it)", path,
+			)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	oldPath, oldDisk := b.FilePath, b.disk
+	s.detachLanguageServer(b)
+	// The file at the new path, if there is one, is no concern of ours, so
+	// there are no changes to it to keep.
+	b.FilePath, b.disk = path, fileStamp{}
+	if err := s.save(force); err != nil {
+		b.FilePath, b.disk = oldPath, oldDisk
+		s.attachLanguageServer(b)
+		return err
+	}
+	b.setLanguage(syntax.Detect(path, b.Text.Line(0)))
+	s.attachLanguageServer(b)
+	return nil
+}
+
+// save writes the current buffer to its file. Unless force is set, it
+// refuses to overwrite changes made to the file outside the editor.
+func (s *State) save(force bool) error {
+	if s.FilePath == "" {
+		return errors.New("no file name (use write path)")
+	}
+	if s.ReadOnly && !force {
+		return errors.New("read-only (use write! to write anyway)")
+	}
+	if !force {
+		if _, changed, err := s.changedOnDisk(); err != nil {
+			return err
+		} else if changed {
+			return fmt.Errorf(
+				"%v has changed on disk (use write! to overwrite it, "+
+					"or reload to load it)",
+				s.FilePath,
+			)
+		}
+	}
+	contents, err := s.encode()
+	if err != nil {
+		return err
+	}
+	if err := writeFile(s.FilePath, contents, s.Backup); err != nil {
+		return err
+	}
+	s.stampFile(contents)
+	s.initHistory()
+	s.saved = s.historyHead
+	s.savedFormat = s.format
+	if err := s.saveHistory(contents); err != nil {
+		s.Msg = "couldn't save undo history: " + err.Error()
+	}
+	if s.server != nil {
+		s.server.DidSave(lsp.URI(s.FilePath))
+	}
+	return nil
+}
+
+// Rescue writes the contents of every modified buffer to a rescue file next
+// to its own file. Buffers with no file, or whose rescue file can't be
+// written, get a new file in the state directory, or in the temporary
+// directory if that fails. It's a last resort for when the editor is
+// crashing, and returns a line for each buffer saying where its contents
+// went.
+func (s *State) Rescue() []string {
+	var report []string
+	for _, b := range s.Buffers {
+		if b.Modified() {
+			report = append(report, b.rescue())
+		}
+	}
+	return report
+}
+
+func (b *Buffer) rescue() (report string) {
+	name := b.FilePath
+	if name == "" {
+		name = "unnamed buffer"
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			report = fmt.Sprintf("couldn't rescue %v: %v", name, r)
+		}
+	}()
+	// Text that can't be written in the file's encoding is better rescued
+	// as UTF-8 than not at all.
+	contents, err := b.encode()
+	if err != nil {
+		contents = []byte(b.contents())
+	}
+	var path string
+	if b.FilePath != "" {
+		path = b.FilePath + ".rescue"
+		err = ioutil.WriteFile(path, contents, 0600)
+	}
+	if b.FilePath == "" || err != nil {
+		pattern := "unnamed.*.rescue"
+		if b.FilePath != "" {
+			pattern = filepath.Base(b.FilePath) + ".*.rescue"
+		}
+		path, err = writeRescueFile(pattern, contents)
+	}
+	if err != nil {
+		return fmt.Sprintf("couldn't rescue %v: %v", name, err)
+	}
+	return fmt.Sprintf("rescued %v to %v", name, path)
+}
+
+// writeRescueFile writes contents to a new file, named after pattern as
+// ioutil.TempFile names files, in the state directory, or in the temporary
+// directory if that fails. It returns the path to the file.
+func writeRescueFile(pattern string, contents []byte) (string, error) {
+	var dirs []string
+	if dir, err := stateDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "rescue"))
+	}
+	dirs = append(dirs, os.TempDir())
+	var err error
+	for _, dir := range dirs {
+		var path string
+		if path, err = writeTempFile(dir, pattern, contents); err == nil {
+			return path, nil
+		}
+	}
+	return "", err
+}
+
+func writeTempFile(dir, pattern string, contents []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, pattern)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	defer f.Close()
-	for _, line := range s.Text {
-		fmt.Fprintln(f, line)
+	_, err = f.Write(contents)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
 	}
+	return f.Name(), nil
 }
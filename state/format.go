@@ -0,0 +1,227 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/callum-oakley/vee/charset"
+)
+
+// A lineEnding is how the lines of a file are terminated. Files with mixed
+// line endings keep the carriage returns in the text, so that they're
+// written back exactly as they were.
+type lineEnding int
+
+const (
+	lf lineEnding = iota
+	crlf
+	mixed
+)
+
+var lineEndingNames = map[lineEnding]string{
+	lf:    "lf",
+	crlf:  "crlf",
+	mixed: "mixed",
+}
+
+// A format is how the text of a buffer is written to its file. A nil
+// encoding is UTF-8, and rest holds any bytes at the end of the file that
+// were too few to decode.
+//
+// A buffer always has at least one line, so a buffer of one blank line is
+// written as an empty file, unless blankLine is set because the file it was
+// read from was a single line ending.
+type format struct {
+	encoding       *charset.Encoding
+	rest           string
+	lineEnding     lineEnding
+	noFinalNewline bool
+	blankLine      bool
+}
+
+func init() {
+	registerOption(
+		"encoding",
+		func(s *State) string {
+			if s.Buffer == nil {
+				return charset.UTF8.Name
+			}
+			return s.encoding().Name
+		},
+		func(s *State, value string) error {
+			if s.Buffer == nil {
+				return errors.New("no buffer")
+			}
+			e := charset.Find(value)
+			if e == nil {
+				return fmt.Errorf("unknown encoding %q", value)
+			}
+			s.format.encoding = e
+			return nil
+		},
+	)
+	registerOption(
+		"lineending",
+		func(s *State) string {
+			if s.Buffer == nil {
+				return "lf"
+			}
+			return lineEndingNames[s.format.lineEnding]
+		},
+		func(s *State, value string) error {
+			if s.Buffer == nil {
+				return errors.New("no buffer")
+			}
+			switch value {
+			case "lf":
+				s.setLineEnding(lf)
+			case "crlf":
+				s.setLineEnding(crlf)
+			default:
+				return fmt.Errorf("invalid line ending %q", value)
+			}
+			return nil
+		},
+	)
+	registerOption(
+		"finalnewline",
+		func(s *State) string {
+			return strconv.FormatBool(
+				s.Buffer == nil || !s.format.noFinalNewline,
+			)
+		},
+		func(s *State, value string) error {
+			if s.Buffer == nil {
+				return errors.New("no buffer")
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", value)
+			}
+			s.format.noFinalNewline = !b
+			return nil
+		},
+	)
+}
+
+func (b *Buffer) encoding() *charset.Encoding {
+	if b.format.encoding == nil {
+		return charset.UTF8
+	}
+	return b.format.encoding
+}
+
+// decode decodes the contents of a file in e, or whatever encoding they
+// seem to be in if e is nil, splits them into lines, and works out the
+// format to write them back in.
+func decode(contents []byte, e *charset.Encoding) ([]string, format) {
+	if e == nil {
+		e = charset.Detect(contents)
+	}
+	text, rest := e.Decode(contents)
+	lines := strings.Split(text, "\n")
+	f := format{encoding: e, rest: string(rest)}
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		f.noFinalNewline = true
+	}
+	terminated := len(lines)
+	if f.noFinalNewline {
+		terminated--
+	}
+	// A carriage return on the unterminated last line, or one before
+	// another at the end of a line, isn't part of a line ending. CRLF would
+	// lose it, so the file is taken to have mixed line endings.
+	crs, stray := 0, false
+	for _, line := range lines[:terminated] {
+		if strings.HasSuffix(line, "\r") {
+			crs++
+		}
+		if strings.HasSuffix(line, "\r\r") {
+			stray = true
+		}
+	}
+	if f.noFinalNewline && strings.HasSuffix(lines[terminated], "\r") {
+		stray = true
+	}
+	if crs > 0 && crs == terminated && !stray {
+		f.lineEnding = crlf
+		for i := range lines[:terminated] {
+			lines[i] = strings.TrimSuffix(lines[i], "\r")
+		}
+	} else if crs > 0 || stray {
+		f.lineEnding = mixed
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	} else if len(lines) == 1 && lines[0] == "" && !f.noFinalNewline {
+		f.blankLine = true
+	}
+	return lines, f
+}
+
+// encode returns the contents of b's file, as it would be saved. With CRLF
+// line endings, a carriage return left at the end of a terminated line, say
+// by undoing a conversion from mixed line endings, is taken as part of the
+// line ending. It fails if the text can't be written in b's encoding.
+func (b *Buffer) encode() ([]byte, error) {
+	ending := "\n"
+	if b.format.lineEnding == crlf {
+		ending = "\r\n"
+	}
+	if b.Text.Len() == 1 && b.Text.Line(0) == "" && !b.format.blankLine {
+		return b.encoding().Encode("", []byte(b.format.rest))
+	}
+	var sb strings.Builder
+	for y := 0; y < b.Text.Len(); y++ {
+		line := b.Text.Line(y)
+		terminated := y < b.Text.Len()-1 || !b.format.noFinalNewline
+		if terminated && b.format.lineEnding == crlf {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		sb.WriteString(line)
+		if terminated {
+			sb.WriteString(ending)
+		}
+	}
+	return b.encoding().Encode(sb.String(), []byte(b.format.rest))
+}
+
+// setLineEnding changes the line ending the current buffer is written with.
+// Converting from mixed line endings removes the carriage returns from the
+// text, as a single change.
+func (s *State) setLineEnding(e lineEnding) {
+	if s.format.lineEnding == mixed {
+		s.startChange()
+		for y := 0; y < s.Text.Len(); y++ {
+			if line := s.Text.Line(y); strings.HasSuffix(line, "\r") {
+				s.applyDiff(diff{
+					start:  y,
+					before: []string{line},
+					after:  []string{strings.TrimSuffix(line, "\r")},
+				})
+			}
+		}
+		s.endChange()
+	}
+	s.format.lineEnding = e
+}
+
+// FormatStatus describes the line endings of the current buffer, and notes
+// its encoding if it isn't UTF-8 and if it has no final newline.
+func (b *Buffer) FormatStatus() string {
+	status := strings.ToUpper(lineEndingNames[b.format.lineEnding])
+	if b.format.lineEnding == mixed {
+		status = "mixed"
+	}
+	if b.encoding() != charset.UTF8 {
+		status = b.encoding().Name + " " + status
+	}
+	if b.format.noFinalNewline {
+		status += " noeol"
+	}
+	return status
+}
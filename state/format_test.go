@@ -0,0 +1,70 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/callum-oakley/vee/charset"
+	"github.com/callum-oakley/vee/text"
+)
+
+func TestDecodeEncode(t *testing.T) {
+	for _, test := range []struct {
+		contents string
+		lines    []string
+		ending   lineEnding
+	}{
+		{"", []string{""}, lf},
+		{"\n", []string{""}, lf},
+		{"a\nb\n", []string{"a", "b"}, lf},
+		{"a\nb", []string{"a", "b"}, lf},
+		{"\r\n", []string{""}, crlf},
+		{"a\r\nb\r\n", []string{"a", "b"}, crlf},
+		{"a\r\nb", []string{"a", "b"}, crlf},
+		{"a\rb\r\n", []string{"a\rb"}, crlf},
+		{"a\nb\r\n", []string{"a", "b\r"}, mixed},
+		// Carriage returns that aren't part of a line ending make the line
+		// endings mixed, so that they're kept.
+		{"a\r\nb\r", []string{"a\r", "b\r"}, mixed},
+		{"x\r\r\n", []string{"x\r\r"}, mixed},
+		{"a\r\r", []string{"a\r\r"}, mixed},
+		{"\r", []string{"\r"}, mixed},
+	} {
+		lines, f := decode([]byte(test.contents), nil)
+		if !reflect.DeepEqual(lines, test.lines) ||
+			f.lineEnding != test.ending {
+			t.Errorf("%q decodes as %q %v, want %q %v", test.contents,
+				lines, lineEndingNames[f.lineEnding],
+				test.lines, lineEndingNames[test.ending])
+		}
+		b := &Buffer{Text: text.New(lines), format: f}
+		contents, err := b.encode()
+		if err != nil || string(contents) != test.contents {
+			t.Errorf("%q encodes as %q, %v", test.contents, contents, err)
+		}
+	}
+}
+
+// TestEncodeStrayCR encodes text with carriage returns left at the ends of
+// lines, as undoing a conversion from mixed line endings leaves them.
+func TestEncodeStrayCR(t *testing.T) {
+	for _, test := range []struct {
+		lines          []string
+		noFinalNewline bool
+		want           string
+	}{
+		{[]string{"a\r", "b\r"}, false, "a\r\nb\r\n"},
+		{[]string{"a\r", "b\r"}, true, "a\r\nb\r"},
+	} {
+		b := &Buffer{Text: text.New(test.lines), format: format{
+			encoding:       charset.UTF8,
+			lineEnding:     crlf,
+			noFinalNewline: test.noFinalNewline,
+		}}
+		contents, err := b.encode()
+		if err != nil || string(contents) != test.want {
+			t.Errorf("%q encodes as %q, %v, want %q",
+				test.lines, contents, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/callum-oakley/vee/lsp"
+)
+
+// LineNumbers is how lines are numbered in the gutter. Relative numbers
+// count lines from the primary cursor, and hybrid numbers are relative
+// except on the cursor's own line, which has its absolute number.
+type LineNumbers int
+
+const (
+	NoLineNumbers LineNumbers = iota
+	AbsoluteLineNumbers
+	RelativeLineNumbers
+	HybridLineNumbers
+)
+
+var lineNumbersNames = map[LineNumbers]string{
+	NoLineNumbers:       "off",
+	AbsoluteLineNumbers: "absolute",
+	RelativeLineNumbers: "relative",
+	HybridLineNumbers:   "hybrid",
+}
+
+// SignColumn is whether the gutter has a column for signs. Automatically,
+// it does when the buffer has any signs to show.
+type SignColumn int
+
+const (
+	AutoSignColumn SignColumn = iota
+	ShowSignColumn
+	HideSignColumn
+)
+
+var signColumnNames = map[SignColumn]string{
+	AutoSignColumn: "auto",
+	ShowSignColumn: "yes",
+	HideSignColumn: "no",
+}
+
+// A Sign marks a line in the gutter with a character, drawn in the style
+// with the given name.
+type Sign struct {
+	Char  rune
+	Style string
+}
+
+var diagnosticSigns = map[lsp.DiagnosticSeverity]Sign{
+	lsp.SeverityError:       {'E', "error"},
+	lsp.SeverityWarning:     {'W', "warning"},
+	lsp.SeverityInformation: {'I', "information"},
+	lsp.SeverityHint:        {'H', "hint"},
+}
+
+func init() {
+	registerOption(
+		"number",
+		func(s *State) string { return lineNumbersNames[s.LineNumbers] },
+		func(s *State, value string) error {
+			for n, name := range lineNumbersNames {
+				if value == name {
+					s.LineNumbers = n
+					return nil
+				}
+			}
+			return fmt.Errorf("invalid line numbers %q", value)
+		},
+	)
+	registerOption(
+		"signcolumn",
+		func(s *State) string { return signColumnNames[s.SignColumn] },
+		func(s *State, value string) error {
+			for c, name := range signColumnNames {
+				if value == name {
+					s.SignColumn = c
+					return nil
+				}
+			}
+			return fmt.Errorf("invalid sign column %q", value)
+		},
+	)
+}
+
+// Signs returns the signs to show in the gutter beside b's lines, by line.
+// For now they mark the lines diagnostics start on, with the most severe
+// diagnostic winning.
+func (b *Buffer) Signs() map[int]Sign {
+	signs := map[int]Sign{}
+	severities := map[int]lsp.DiagnosticSeverity{}
+	for _, d := range b.Diagnostics {
+		sign, ok := diagnosticSigns[d.Severity]
+		if !ok {
+			sign = diagnosticSigns[lsp.SeverityError]
+		}
+		y := d.From.Y
+		if severity, ok := severities[y]; !ok || d.Severity < severity {
+			signs[y], severities[y] = sign, d.Severity
+		}
+	}
+	return signs
+}
@@ -1,5 +1,7 @@
 package state
 
+import "fmt"
+
 type diff struct {
 	start         int
 	before, after []string
@@ -7,7 +9,57 @@ type diff struct {
 
 type change struct {
 	diff
-	anchorBefore, anchorAfter, cursorBefore, cursorAfter cursor
+	selectionsBefore, selectionsAfter []Selection
+}
+
+// An undoNode is a state in the undo tree, reached by applying its change to
+// its parent. No change is ever discarded: editing after an undo starts a new
+// branch.
+type undoNode struct {
+	change
+	// seq is the position of the node in the order states were created. The
+	// root, which is the state before any changes, has seq 0.
+	seq      int
+	parent   *undoNode
+	children []*undoNode
+	// redo is the index of the child that redo moves to, which is whichever
+	// was visited most recently.
+	redo int
+}
+
+func (n *undoNode) branch() int {
+	if n.parent == nil {
+		return 0
+	}
+	for i, child := range n.parent.children {
+		if child == n {
+			return i
+		}
+	}
+	panic("undo node missing from its parent")
+}
+
+// describe summarises n's position in a tree of total changes for the
+// message line.
+func (n *undoNode) describe(total int) string {
+	msg := fmt.Sprintf("change %v of %v", n.seq, total)
+	if n.parent != nil && len(n.parent.children) > 1 {
+		msg += fmt.Sprintf(
+			", branch %v of %v",
+			n.branch()+1, len(n.parent.children),
+		)
+	}
+	if len(n.children) > 1 {
+		msg += fmt.Sprintf(", %v branches follow", len(n.children))
+	}
+	return msg
+}
+
+func (s *State) initHistory() {
+	if s.historyHead == nil {
+		s.historyHead = &undoNode{}
+		s.history = []*undoNode{s.historyHead}
+	}
 }
 
 func (d *diff) isEmpty() bool {
@@ -34,6 +86,23 @@ func revert(d diff, text []string) []string {
 	)
 }
 
+// apply applies d to the buffer's text and tells anyone watching the buffer
+// about it.
+func (b *Buffer) apply(d diff) {
+	if d.isEmpty() {
+		return
+	}
+	b.Text.Replace(d.start, d.start+len(d.before), d.after)
+	b.shiftDiagnostics(d)
+	for _, f := range b.onChange {
+		f(d)
+	}
+}
+
+func (b *Buffer) revert(d diff) {
+	b.apply(diff{start: d.start, before: d.after, after: d.before})
+}
+
 func compose(b diff, a diff) diff {
 	if a.isEmpty() {
 		return b
@@ -65,20 +134,43 @@ func compose(b diff, a diff) diff {
 }
 
 func (s *State) startChange() {
-	s.change = change{
-		anchorBefore: s.Anchor,
-		cursorBefore: s.Cursor,
+	if s.group > 0 {
+		return
 	}
+	s.change = change{selectionsBefore: s.Selections()}
 }
 
 func (s *State) endChange() {
-	if s.change.isEmpty() {
+	if s.group > 0 || s.change.isEmpty() {
 		return
 	}
-	s.change.anchorAfter = s.Anchor
-	s.change.cursorAfter = s.Cursor
-	s.history = append(s.history[:s.historyHead], s.change)
-	s.historyHead++
+	s.change.selectionsAfter = s.Selections()
+	s.initHistory()
+	node := &undoNode{
+		change: s.change,
+		seq:    len(s.history),
+		parent: s.historyHead,
+	}
+	s.historyHead.redo = len(s.historyHead.children)
+	s.historyHead.children = append(s.historyHead.children, node)
+	s.history = append(s.history, node)
+	s.historyHead = node
+}
+
+// beginGroup starts a change group, which lasts until the matching endGroup.
+// Every change made in between is undone and redone as one.
+func (s *State) beginGroup() {
+	s.startChange()
+	s.group++
+}
+
+func (s *State) endGroup() {
+	s.group--
+	s.endChange()
+	if s.group == 0 && s.mode == modeInsert {
+		// Anything typed after the group is a change of its own.
+		s.startChange()
+	}
 }
 
 func (s *State) applyDiff(d diff) {
@@ -90,28 +182,100 @@ func (s *State) applyDiff(d diff) {
 	d.after = make([]string, len(after))
 	copy(d.after, after)
 
-	s.Text = apply(d, s.Text)
-	s.change.diff = compose(d, s.change.diff)
+	// compose needs the diffs it combines to touch, so widen d to cover any
+	// untouched lines between it and the change so far.
+	wide := d
+	if c := s.change.diff; !c.isEmpty() {
+		if end := c.start + len(c.after); end < d.start {
+			gap := s.Text.Lines(end, d.start)
+			wide.start = end
+			wide.before = append(append([]string{}, gap...), d.before...)
+			wide.after = append(append([]string{}, gap...), d.after...)
+		} else if end := d.start + len(d.before); end < c.start {
+			gap := s.Text.Lines(end, c.start)
+			wide.before = append(append([]string{}, d.before...), gap...)
+			wide.after = append(append([]string{}, d.after...), gap...)
+		}
+	}
+
+	s.apply(d)
+	s.change.diff = compose(wide, s.change.diff)
+	for i := range s.visited {
+		s.shift(&s.visited[i].Anchor, d)
+		s.shift(&s.visited[i].Cursor, d)
+	}
 }
 
 func (s *State) undo() {
-	if s.historyHead == 0 {
+	if s.historyHead == nil || s.historyHead.parent == nil {
 		return
 	}
-	s.historyHead--
-	s.Text = revert(s.history[s.historyHead].diff, s.Text)
-	s.Anchor = s.history[s.historyHead].anchorBefore
-	s.Cursor = s.history[s.historyHead].cursorBefore
+	s.undoTo(s.historyHead.parent)
 }
 
 func (s *State) redo() {
-	if s.historyHead >= len(s.history) {
+	if s.historyHead == nil || len(s.historyHead.children) == 0 {
 		return
 	}
-	s.Text = apply(s.history[s.historyHead].diff, s.Text)
-	s.Anchor = s.history[s.historyHead].anchorAfter
-	s.Cursor = s.history[s.historyHead].cursorAfter
-	s.historyHead++
+	s.undoTo(s.historyHead.children[s.historyHead.redo])
+}
+
+// older and newer move to the previous or next state in the order in which
+// they were created, regardless of which branch they're on.
+func (s *State) older() {
+	if s.historyHead == nil || s.historyHead.seq == 0 {
+		return
+	}
+	s.undoTo(s.history[s.historyHead.seq-1])
+}
+
+func (s *State) newer() {
+	if s.historyHead == nil || s.historyHead.seq == len(s.history)-1 {
+		return
+	}
+	s.undoTo(s.history[s.historyHead.seq+1])
+}
+
+// sibling moves to the state on the branch n along from the current one
+// which shares the current state's parent.
+func (s *State) sibling(n int) {
+	if s.historyHead == nil || s.historyHead.parent == nil {
+		return
+	}
+	siblings := s.historyHead.parent.children
+	i := s.historyHead.branch()
+	s.undoTo(siblings[((i+n)%len(siblings)+len(siblings))%len(siblings)])
+}
+
+// undoTo reverts changes up to the common ancestor of the current state and
+// target, then applies changes down to target.
+func (s *State) undoTo(target *undoNode) {
+	var down []*undoNode
+	var selections []Selection
+	head := s.historyHead
+	for head != target {
+		if head.seq > target.seq {
+			s.revert(head.diff)
+			selections = head.selectionsBefore
+			head = head.parent
+		} else {
+			down = append(down, target)
+			target = target.parent
+		}
+	}
+	for i := len(down) - 1; i >= 0; i-- {
+		s.apply(down[i].diff)
+		selections = down[i].selectionsAfter
+		down[i].parent.redo = down[i].branch()
+	}
+	if len(down) > 0 {
+		head = down[0]
+	}
+	s.historyHead = head
+	if selections != nil {
+		s.setSelections(selections)
+	}
+	s.Msg = s.historyHead.describe(len(s.history) - 1)
 }
 
 func min(x, y int) int {
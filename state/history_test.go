@@ -0,0 +1,183 @@
+package state
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newState returns a state with an unnamed buffer holding lines, and no
+// history.
+func newState(t *testing.T, lines ...string) *State {
+	t.Helper()
+	os.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Cleanup(func() { os.Unsetenv("XDG_STATE_HOME") })
+	s := &State{TabWidth: 4}
+	if err := s.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	s.Text.Replace(0, 1, lines)
+	return s
+}
+
+func lines(s *State) string {
+	return strings.Join(s.Text.Lines(0, s.Text.Len()), " ")
+}
+
+// TestCompose composes diffs that touch, as compose needs them to.
+func TestCompose(t *testing.T) {
+	text := strings.Fields("0 1 2 3 4 5")
+	for _, test := range []struct {
+		name string
+		a, b diff
+	}{
+		{
+			"b inside a",
+			diff{1, []string{"1", "2"}, []string{"a", "b", "c"}},
+			diff{2, []string{"b"}, []string{"x"}},
+		},
+		{
+			"b overlaps the end of a",
+			diff{1, []string{"1"}, []string{"a", "b"}},
+			diff{2, []string{"b", "2", "3"}, []string{"x"}},
+		},
+		{
+			"b overlaps the start of a",
+			diff{2, []string{"2", "3"}, []string{"a"}},
+			diff{1, []string{"1", "a"}, []string{"x", "y", "z"}},
+		},
+		{
+			"b covers a",
+			diff{2, []string{"2"}, []string{"a"}},
+			diff{0, []string{"0", "1", "a", "3"}, nil},
+		},
+		{
+			"b follows a",
+			diff{1, []string{"1"}, []string{"a"}},
+			diff{2, []string{"2"}, []string{"x", "y"}},
+		},
+		{
+			"b inserts where a deleted",
+			diff{3, []string{"3"}, nil},
+			diff{3, nil, []string{"x"}},
+		},
+		{
+			"b precedes a",
+			diff{3, []string{"3"}, []string{"a"}},
+			diff{1, []string{"1", "2"}, []string{"x"}},
+		},
+		{
+			"a is empty",
+			diff{},
+			diff{2, []string{"2"}, []string{"x"}},
+		},
+	} {
+		copied := append([]string{}, text...)
+		want := apply(test.b, apply(test.a, copied))
+		c := compose(test.b, test.a)
+		got := apply(c, append([]string{}, text...))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: applying gives %q, want %q", test.name, got, want)
+		}
+		if got := revert(c, got); !reflect.DeepEqual(got, text) {
+			t.Errorf("%v: reverting gives %q, want %q", test.name, got, text)
+		}
+	}
+}
+
+// TestMultipleSelections edits at selections that are far apart, which
+// applyDiff has to widen to compose them into one change.
+func TestMultipleSelections(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		ys    []int
+		keys  string
+		after string
+	}{
+		{"insert apart", []int{0, 3, 6}, "a x <Esc>",
+			"x0 1 2 x3 4 5 x6"},
+		{"delete apart", []int{1, 5}, "x",
+			"0  2 3 4  6"},
+		{"new lines apart", []int{0, 4}, "d <CR> <Esc>",
+			"0  1 2 3 4  5 6"},
+		{"delete lines apart", []int{0, 2, 6}, "X",
+			"1 3 4 5"},
+		{"adjacent", []int{2, 3}, "a x <Esc>",
+			"0 1 x2 x3 4 5 6"},
+	} {
+		s := newState(t, strings.Fields("0 1 2 3 4 5 6")...)
+		before := lines(s)
+		var sels []Selection
+		for _, y := range test.ys {
+			c := cursor{Y: y}
+			sels = append(sels, Selection{c, c})
+		}
+		s.setSelections(sels)
+		press(t, s, test.keys)
+		if got := lines(s); got != test.after {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.after)
+		}
+		s.undo()
+		if got := lines(s); got != before {
+			t.Errorf("%v: undo gives %q, want %q", test.name, got, before)
+		}
+		s.redo()
+		if got := lines(s); got != test.after {
+			t.Errorf("%v: redo gives %q, want %q", test.name, got, test.after)
+		}
+		if len(s.history) != 2 {
+			t.Errorf("%v: %v changes, want 1", test.name, len(s.history)-1)
+		}
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	s := newState(t, "a")
+	set := func(line string) {
+		edit(s, diff{start: 0, before: []string{s.Text.Line(0)},
+			after: []string{line}})
+	}
+	set("b")
+	set("c")
+	s.undo()
+	set("d") // starts a second branch after b
+	for _, step := range []struct {
+		name string
+		f    func()
+		line string
+		msg  string
+	}{
+		{"undo", s.undo, "b", "change 1 of 3, 2 branches follow"},
+		{"redo to the newer branch", s.redo, "d",
+			"change 3 of 3, branch 2 of 2"},
+		{"sibling", func() { s.sibling(1) }, "c",
+			"change 2 of 3, branch 1 of 2"},
+		{"undo", s.undo, "b", "change 1 of 3, 2 branches follow"},
+		{"redo to the branch last visited", s.redo, "c",
+			"change 2 of 3, branch 1 of 2"},
+		{"older", s.older, "b", "change 1 of 3, 2 branches follow"},
+		{"newer", s.newer, "c", "change 2 of 3, branch 1 of 2"},
+		{"newer across branches", s.newer, "d",
+			"change 3 of 3, branch 2 of 2"},
+		{"newer at the newest", s.newer, "d", ""},
+		{"undo", s.undo, "b", "change 1 of 3, 2 branches follow"},
+		{"undo to the root", s.undo, "a", "change 0 of 3"},
+		{"undo at the root", s.undo, "a", ""},
+		{"older at the root", s.older, "a", ""},
+		{"redo", s.redo, "b", "change 1 of 3, 2 branches follow"},
+	} {
+		s.Msg = ""
+		step.f()
+		if got := s.Text.Line(0); got != step.line || s.Msg != step.msg {
+			t.Errorf("%v: got %q %q, want %q %q",
+				step.name, got, s.Msg, step.line, step.msg)
+		}
+	}
+	// Editing never discards a branch.
+	set("e")
+	if len(s.history) != 5 || len(s.history[1].children) != 3 {
+		t.Errorf("got %v states, want 5 with 3 branches after b",
+			len(s.history))
+	}
+}
@@ -4,10 +4,10 @@ func (s *State) insert(char rune) {
 	if char == '\n' {
 		s.applyDiff(diff{
 			start:  s.Cursor.Y,
-			before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
+			before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
 			after: []string{
-				s.Text[s.Cursor.Y][:s.Cursor.X],
-				s.Text[s.Cursor.Y][s.Cursor.X:],
+				s.Text.Line(s.Cursor.Y)[:s.Cursor.X],
+				s.Text.Line(s.Cursor.Y)[s.Cursor.X:],
 			},
 		})
 		s.setCursorY(&s.Cursor, s.Cursor.Y+1)
@@ -17,9 +17,9 @@ func (s *State) insert(char rune) {
 	}
 	s.applyDiff(diff{
 		start:  s.Cursor.Y,
-		before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
-		after: []string{s.Text[s.Cursor.Y][:s.Cursor.X] + string(char) +
-			s.Text[s.Cursor.Y][s.Cursor.X:]},
+		before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
+		after: []string{s.Text.Line(s.Cursor.Y)[:s.Cursor.X] + string(char) +
+			s.Text.Line(s.Cursor.Y)[s.Cursor.X:]},
 	})
 	s.setCursorX(&s.Cursor, s.xRightOf(&s.Cursor))
 	s.Anchor = s.Cursor
@@ -29,11 +29,13 @@ func (s *State) insertBackspace() {
 	if s.Cursor.X == 0 && s.Cursor.Y == 0 {
 		return
 	} else if s.Cursor.X == 0 {
-		x := len(s.Text[s.Cursor.Y-1])
+		x := len(s.Text.Line(s.Cursor.Y - 1))
 		s.applyDiff(diff{
 			start:  s.Cursor.Y - 1,
-			before: s.Text[s.Cursor.Y-1 : s.Cursor.Y+1],
-			after:  []string{s.Text[s.Cursor.Y-1] + s.Text[s.Cursor.Y]},
+			before: s.Text.Lines(s.Cursor.Y-1, s.Cursor.Y+1),
+			after: []string{
+				s.Text.Line(s.Cursor.Y-1) + s.Text.Line(s.Cursor.Y),
+			},
 		})
 		s.setCursorY(&s.Cursor, s.Cursor.Y-1)
 		s.setCursorX(&s.Cursor, x)
@@ -43,9 +45,10 @@ func (s *State) insertBackspace() {
 	newCursorX := s.xLeftOf(&s.Cursor)
 	s.applyDiff(diff{
 		start:  s.Cursor.Y,
-		before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
+		before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
 		after: []string{
-			s.Text[s.Cursor.Y][:newCursorX] + s.Text[s.Cursor.Y][s.Cursor.X:],
+			s.Text.Line(s.Cursor.Y)[:newCursorX] +
+				s.Text.Line(s.Cursor.Y)[s.Cursor.X:],
 		},
 	})
 	s.setCursorX(&s.Cursor, newCursorX)
@@ -59,13 +62,14 @@ func (s *State) insertBackspaceWord() {
 		s.insertBackspace()
 		return
 	} else if match := reStartOfWord.FindStringIndex(
-		s.Text[s.Cursor.Y][:s.Cursor.X],
+		s.Text.Line(s.Cursor.Y)[:s.Cursor.X],
 	); match != nil {
 		s.applyDiff(diff{
 			start:  s.Cursor.Y,
-			before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
+			before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
 			after: []string{
-				s.Text[s.Cursor.Y][:match[0]] + s.Text[s.Cursor.Y][s.Cursor.X:],
+				s.Text.Line(s.Cursor.Y)[:match[0]] +
+					s.Text.Line(s.Cursor.Y)[s.Cursor.X:],
 			},
 		})
 		s.setCursorX(&s.Cursor, match[0])
@@ -73,8 +77,8 @@ func (s *State) insertBackspaceWord() {
 	} else {
 		s.applyDiff(diff{
 			start:  s.Cursor.Y,
-			before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
-			after:  []string{s.Text[s.Cursor.Y][s.Cursor.X:]},
+			before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
+			after:  []string{s.Text.Line(s.Cursor.Y)[s.Cursor.X:]},
 		})
 		s.setCursorX(&s.Cursor, 0)
 		s.Anchor = s.Cursor
@@ -82,22 +86,25 @@ func (s *State) insertBackspaceWord() {
 }
 
 func (s *State) insertDelete() {
-	if s.Cursor.X >= len(s.Text[s.Cursor.Y]) && s.Cursor.Y == len(s.Text)-1 {
+	if s.Cursor.X >= len(s.Text.Line(s.Cursor.Y)) &&
+		s.Cursor.Y == s.Text.Len()-1 {
 		return
-	} else if s.Cursor.X >= len(s.Text[s.Cursor.Y]) {
+	} else if s.Cursor.X >= len(s.Text.Line(s.Cursor.Y)) {
 		s.applyDiff(diff{
 			start:  s.Cursor.Y,
-			before: s.Text[s.Cursor.Y : s.Cursor.Y+2],
-			after:  []string{s.Text[s.Cursor.Y] + s.Text[s.Cursor.Y+1]},
+			before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+2),
+			after: []string{
+				s.Text.Line(s.Cursor.Y) + s.Text.Line(s.Cursor.Y+1),
+			},
 		})
 		return
 	}
 	s.applyDiff(diff{
 		start:  s.Cursor.Y,
-		before: s.Text[s.Cursor.Y : s.Cursor.Y+1],
+		before: s.Text.Lines(s.Cursor.Y, s.Cursor.Y+1),
 		after: []string{
-			s.Text[s.Cursor.Y][:s.Cursor.X] +
-				s.Text[s.Cursor.Y][s.xRightOf(&s.Cursor):],
+			s.Text.Line(s.Cursor.Y)[:s.Cursor.X] +
+				s.Text.Line(s.Cursor.Y)[s.xRightOf(&s.Cursor):],
 		},
 	})
 }
@@ -0,0 +1,490 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// actions are the named things a key can be bound to. A key can also be
+// bound to a command line, written with a leading ":".
+var actions = map[string]func(s *State){
+	// mode transitions
+	"insert": func(s *State) {
+		s.startChange()
+		s.setMode(modeInsert)
+	},
+	"insert-line-above": func(s *State) {
+		s.startChange()
+		s.forEachSelection(s.newLineAbove)
+		s.setMode(modeInsert)
+	},
+	"append": func(s *State) {
+		s.startChange()
+		s.forEachSelection(func() {
+			s.setCursorX(&s.Cursor, s.xRightOf(&s.Cursor))
+		})
+		s.setMode(modeInsert)
+	},
+	"insert-line-below": func(s *State) {
+		s.startChange()
+		s.move(s.moveEndOfLine)
+		s.forEachSelection(func() {
+			s.setCursorX(&s.Cursor, s.xRightOf(&s.Cursor))
+		})
+		s.setMode(modeInsert)
+		s.forEachSelection(func() { s.insert('\n') })
+	},
+	"change": func(s *State) {
+		s.startChange()
+		s.forEachSelection(s.delete)
+		s.setMode(modeInsert)
+	},
+	"change-lines": func(s *State) {
+		s.startChange()
+		s.forEachSelection(func() {
+			s.deleteLines()
+			s.newLineAbove()
+		})
+		s.setMode(modeInsert)
+	},
+	"normal":  func(s *State) { s.setMode(modeNormal) },
+	"search":  (*State).startSearch,
+	"command": (*State).startCommand,
+	"replace": (*State).startReplace,
+
+	// movements
+	"start-of-line":        func(s *State) { s.move(s.moveStartOfLine) },
+	"extend-start-of-line": func(s *State) { s.extend(s.moveStartOfLine) },
+	"end-of-line":          func(s *State) { s.move(s.moveEndOfLine) },
+	"extend-end-of-line":   func(s *State) { s.extend(s.moveEndOfLine) },
+	"start-of-word":        func(s *State) { s.move(s.moveStartOfWord) },
+	"extend-start-of-word": func(s *State) { s.extend(s.moveStartOfWord) },
+	"end-of-word":          func(s *State) { s.move(s.moveEndOfWord) },
+	"extend-end-of-word":   func(s *State) { s.extend(s.moveEndOfWord) },
+	"left":                 func(s *State) { s.move(s.moveLeft) },
+	"extend-left":          func(s *State) { s.extend(s.moveLeft) },
+	"right":                func(s *State) { s.move(s.moveRight) },
+	"extend-right":         func(s *State) { s.extend(s.moveRight) },
+	"up":                   func(s *State) { s.move(s.up(1)) },
+	"extend-up":            func(s *State) { s.extend(s.up(1)) },
+	"down":                 func(s *State) { s.move(s.down(1)) },
+	"extend-down":          func(s *State) { s.extend(s.down(1)) },
+	"page-up":              func(s *State) { s.move(s.up(9)) },
+	"extend-page-up":       func(s *State) { s.extend(s.up(9)) },
+	"page-down":            func(s *State) { s.move(s.down(9)) },
+	"extend-page-down":     func(s *State) { s.extend(s.down(9)) },
+
+	// selections
+	"add-cursor-below": (*State).addCursorBelow,
+	"split-lines":      (*State).splitLines,
+	"keep-primary":     (*State).keepPrimarySelection,
+	"collapse": func(s *State) {
+		s.collapseSelections()
+		s.mergeSelections()
+	},
+
+	// search
+	"next-match": func(s *State) { s.selectMatch(s.searchForward) },
+	"extend-next-match": func(s *State) {
+		s.extendToMatch(s.searchForward)
+	},
+	"previous-match": func(s *State) { s.selectMatch(s.searchBackward) },
+	"extend-previous-match": func(s *State) {
+		s.extendToMatch(s.searchBackward)
+	},
+
+	// actions
+	"delete": func(s *State) {
+		s.startChange()
+		s.forEachSelection(s.delete)
+		s.endChange()
+	},
+	"delete-lines": func(s *State) {
+		s.startChange()
+		s.forEachSelection(s.deleteLines)
+		s.endChange()
+	},
+	"undo":            (*State).undo,
+	"redo":            (*State).redo,
+	"older":           (*State).older,
+	"newer":           (*State).newer,
+	"previous-branch": func(s *State) { s.sibling(-1) },
+	"next-branch":     func(s *State) { s.sibling(1) },
+	"save":            func(s *State) { s.report(s.save(false)) },
+	"copy":            (*State).copy,
+	"paste":           (*State).pasteAll,
+	"select-register": (*State).selectRegister,
+
+	// buffers
+	"list-buffers":    (*State).listBuffers,
+	"next-buffer":     func(s *State) { s.cycleBuffers(1) },
+	"previous-buffer": func(s *State) { s.cycleBuffers(-1) },
+
+	// language servers
+	"definition":  func(s *State) { s.report(cmdDefinition(s, nil)) },
+	"hover":       func(s *State) { s.report(cmdHover(s, nil)) },
+	"diagnostics": func(s *State) { s.report(cmdDiagnostics(s, nil)) },
+
+	// insert mode
+	"insert-tab":     func(s *State) { s.insertEach('\t') },
+	"insert-newline": func(s *State) { s.insertEach('\n') },
+	"backspace":      func(s *State) { s.forEachSelection(s.insertBackspace) },
+	"backspace-word": func(s *State) {
+		s.forEachSelection(s.insertBackspaceWord)
+	},
+	"delete-forward": func(s *State) { s.forEachSelection(s.insertDelete) },
+}
+
+func (s *State) insertEach(char rune) {
+	s.forEachSelection(func() { s.insert(char) })
+}
+
+func (s *State) up(n int) func(*cursor) {
+	return func(c *cursor) { s.moveUp(c, n) }
+}
+
+func (s *State) down(n int) func(*cursor) {
+	return func(c *cursor) { s.moveDown(c, n) }
+}
+
+var defaultKeymaps = map[mode]map[string]string{
+	modeNormal: {
+		"/":         "search",
+		":":         "command",
+		"a":         "insert",
+		"A":         "insert-line-above",
+		"d":         "append",
+		"D":         "insert-line-below",
+		"f":         "change",
+		"F":         "change-lines",
+		"y":         "start-of-line",
+		"Y":         "extend-start-of-line",
+		"o":         "end-of-line",
+		"O":         "extend-end-of-line",
+		"u":         "start-of-word",
+		"U":         "extend-start-of-word",
+		"i":         "end-of-word",
+		"I":         "extend-end-of-word",
+		"h":         "left",
+		"H":         "extend-left",
+		"l":         "right",
+		"L":         "extend-right",
+		"k":         "up",
+		"K":         "extend-up",
+		"j":         "down",
+		"J":         "extend-down",
+		"<Up>":      "page-up",
+		"<S-Up>":    "extend-page-up",
+		"<Down>":    "page-down",
+		"<S-Down>":  "extend-page-down",
+		"C":         "add-cursor-below",
+		"s":         "split-lines",
+		",":         "keep-primary",
+		"<Esc>":     "collapse",
+		"n":         "next-match",
+		"N":         "extend-next-match",
+		"p":         "previous-match",
+		"P":         "extend-previous-match",
+		"r":         "replace",
+		"x":         "delete",
+		"X":         "delete-lines",
+		"z":         "undo",
+		"Z":         "redo",
+		"[":         "older",
+		"]":         "newer",
+		"{":         "previous-branch",
+		"}":         "next-branch",
+		"w":         "save",
+		"c":         "copy",
+		"v":         "paste",
+		"\"":        "select-register",
+		"q":         "record-macro",
+		"@":         "play-macro",
+		"<Space> q": ":quit!",
+		"<Space> b": "list-buffers",
+		"<Space> n": "next-buffer",
+		"<Space> p": "previous-buffer",
+		"<Space> d": "definition",
+		"<Space> h": "hover",
+		"<Space> e": "diagnostics",
+		// Windows.
+		"<Space> w s": ":split",
+		"<Space> w v": ":vsplit",
+		"<Space> w c": ":close",
+		"<Space> w o": ":only",
+		"<Space> w w": ":focus next",
+		"<Space> w h": ":focus left",
+		"<Space> w j": ":focus down",
+		"<Space> w k": ":focus up",
+		"<Space> w l": ":focus right",
+		"<Space> w +": ":resize +1",
+		"<Space> w -": ":resize -1",
+	},
+	modeInsert: {
+		"<Tab>": "insert-tab",
+		"<CR>":  "insert-newline",
+		"<BS>":  "backspace",
+		"<C-w>": "backspace-word",
+		"<Del>": "delete-forward",
+		"<Esc>": "normal",
+	},
+}
+
+// keymapModes are the modes with keymaps, by name.
+var keymapModes = map[string]mode{
+	"normal": modeNormal,
+	"insert": modeInsert,
+}
+
+// defaultKeyTimeout is how long to wait for the rest of a key sequence when
+// the keys so far are bound as they are but could also be the start of a
+// longer sequence.
+const defaultKeyTimeout = time.Second
+
+func init() {
+	RegisterCommand("map", cmdMap)
+	RegisterCommand("unmap", cmdUnmap)
+	RegisterCommand("bindings", cmdBindings)
+	registerOption(
+		"keytimeout",
+		func(s *State) string {
+			return strconv.Itoa(int(s.keyTimeout() / time.Millisecond))
+		},
+		func(s *State, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid key timeout %q", value)
+			}
+			s.KeyTimeout = time.Duration(n) * time.Millisecond
+			return nil
+		},
+	)
+}
+
+func (s *State) keyTimeout() time.Duration {
+	if s.KeyTimeout == 0 {
+		return defaultKeyTimeout
+	}
+	return s.KeyTimeout
+}
+
+// keymap returns the bindings for m, keyed by key sequence in canonical
+// notation.
+func (s *State) keymap(m mode) map[string]string {
+	if s.keymaps == nil {
+		s.keymaps = map[mode]map[string]string{}
+		for m, defaults := range defaultKeymaps {
+			keymap := map[string]string{}
+			for notation, binding := range defaults {
+				keys, err := parseKeys(notation)
+				if err != nil {
+					panic(err)
+				}
+				keymap[formatKeys(keys)] = binding
+			}
+			s.keymaps[m] = keymap
+		}
+	}
+	return s.keymaps[m]
+}
+
+// HandleKey handles a key press, and reports whether the editor should
+// quit.
+func (s *State) HandleKey(e *tcell.EventKey) bool {
+	if len(s.pending) == 0 && s.mode == modeNormal {
+		s.Msg = ""
+	}
+	k := keyOf(e)
+	if s.recording != nil {
+		s.recording.keys = append(s.recording.keys, k)
+	}
+	s.pending = append(s.pending, k)
+	s.resolveKeys(false)
+	return s.quit
+}
+
+// PendingKeys returns the keys typed so far of a sequence that isn't
+// complete yet.
+func (s *State) PendingKeys() string {
+	if s.register != 0 {
+		return strings.TrimSpace(
+			fmt.Sprintf("\"%c %v", s.register, formatKeys(s.pending)),
+		)
+	}
+	return formatKeys(s.pending)
+}
+
+// resolveKeys runs the bindings for the pending keys. If the pending keys
+// could be the start of a longer sequence, it waits for more keys, or until
+// the key timeout passes, which calls resolveKeys again with timedOut set.
+func (s *State) resolveKeys(timedOut bool) {
+	for len(s.pending) > 0 {
+		if f := s.keyHandler; f != nil {
+			k := s.pending[0]
+			s.pending = s.pending[1:]
+			s.keyHandler = nil
+			f(k)
+			continue
+		}
+		switch s.mode {
+		case modePrompt:
+			s.handlePromptKey(s.pending[0].event())
+			s.pending = s.pending[1:]
+			continue
+		case modeMenu:
+			s.handleMenuKey(s.pending[0].event())
+			s.pending = s.pending[1:]
+			continue
+		}
+		keymap := s.keymap(s.mode)
+		sequence := formatKeys(s.pending)
+		if !timedOut && hasLongerBinding(keymap, sequence) {
+			s.pendingTimer++
+			id := s.pendingTimer
+			time.AfterFunc(s.keyTimeout(), func() {
+				s.interrupt(func() {
+					if s.pendingTimer == id {
+						s.resolveKeys(true)
+					}
+				})
+			})
+			return
+		}
+		timedOut = false
+		if binding, ok := keymap[sequence]; ok {
+			s.lastSequence = s.pending
+			s.pending = nil
+			s.runBinding(binding)
+			continue
+		}
+		// Nothing is bound to the whole sequence, so handle its first key
+		// alone, and then start again from the next. If the first key does
+		// nothing alone, and is only the start of longer sequences, the
+		// whole sequence is a mistake and is dropped.
+		k := s.pending[0]
+		if len(s.pending) > 1 {
+			if _, ok := keymap[formatKeys(s.pending[:1])]; !ok &&
+				!s.insertable(k) {
+				s.pending = nil
+				return
+			}
+			rest := s.pending[1:]
+			s.pending = s.pending[:1]
+			s.resolveKeys(true)
+			s.pending = append(s.pending, rest...)
+			continue
+		}
+		s.pending = nil
+		if s.insertable(k) {
+			s.insertEach(k.r)
+		}
+	}
+}
+
+// insertable reports whether k is inserted as text when nothing is bound to
+// it.
+func (s *State) insertable(k key) bool {
+	return s.mode == modeInsert && k.k == tcell.KeyRune &&
+		k.mod&^tcell.ModShift == 0
+}
+
+// awaitKey passes the next key to f instead of looking it up in the keymap.
+func (s *State) awaitKey(f func(key)) {
+	s.keyHandler = f
+}
+
+// hasLongerBinding reports whether any binding in keymap starts with, but
+// isn't, sequence.
+func hasLongerBinding(keymap map[string]string, sequence string) bool {
+	for s := range keymap {
+		if strings.HasPrefix(s, sequence+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *State) runBinding(binding string) {
+	if strings.HasPrefix(binding, ":") {
+		s.report(s.runCommand(binding[1:]))
+		return
+	}
+	actions[binding](s)
+}
+
+// parseMapArgs splits the arguments of map and unmap into a mode, which is
+// normal unless the first argument names one, and the rest.
+func parseMapArgs(args []string) (mode, []string) {
+	if len(args) > 0 {
+		if m, ok := keymapModes[args[0]]; ok {
+			return m, args[1:]
+		}
+	}
+	return modeNormal, args
+}
+
+// cmdMap binds a key sequence to an action or, if the first word after the
+// keys starts with ":", to a command line.
+func cmdMap(s *State, args []string) error {
+	m, args := parseMapArgs(args)
+	if len(args) < 2 {
+		return errors.New("usage: map [mode] keys action|:command...")
+	}
+	keys, err := parseKeys(args[0])
+	if err != nil {
+		return err
+	}
+	binding := args[1]
+	if strings.HasPrefix(binding, ":") {
+		binding = ":" + strings.Join(quoteWords(
+			append([]string{binding[1:]}, args[2:]...),
+		), " ")
+	} else if _, ok := actions[binding]; !ok || len(args) > 2 {
+		return fmt.Errorf("unknown action %q", strings.Join(args[1:], " "))
+	}
+	s.keymap(m)[formatKeys(keys)] = binding
+	return nil
+}
+
+func cmdUnmap(s *State, args []string) error {
+	m, args := parseMapArgs(args)
+	if len(args) != 1 {
+		return errors.New("usage: unmap [mode] keys")
+	}
+	keys, err := parseKeys(args[0])
+	if err != nil {
+		return err
+	}
+	delete(s.keymap(m), formatKeys(keys))
+	return nil
+}
+
+// cmdBindings lists the bindings for a mode in a menu.
+func cmdBindings(s *State, args []string) error {
+	m, args := parseMapArgs(args)
+	if len(args) != 0 {
+		return errors.New("usage: bindings [mode]")
+	}
+	keymap := s.keymap(m)
+	var sequences []string
+	width := 0
+	for sequence := range keymap {
+		sequences = append(sequences, sequence)
+		width = max(width, len(sequence))
+	}
+	sort.Strings(sequences)
+	var items []string
+	for _, sequence := range sequences {
+		items = append(items, fmt.Sprintf(
+			"%-*v  %v", width, sequence, keymap[sequence],
+		))
+	}
+	s.startMenu(items, -1, func(int) {})
+	return nil
+}
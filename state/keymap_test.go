@@ -0,0 +1,146 @@
+package state
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// keymapState returns a state with a buffer holding a single line of text.
+// Functions passed to Interrupt are queued until pump runs them.
+func keymapState(t *testing.T, line string) (*State, chan func()) {
+	t.Helper()
+	os.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Cleanup(func() { os.Unsetenv("XDG_STATE_HOME") })
+	interrupts := make(chan func(), 64)
+	s := &State{
+		TabWidth:   4,
+		KeyTimeout: time.Millisecond,
+		Interrupt:  func(f func()) { interrupts <- f },
+	}
+	if err := s.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	edit(s, diff{start: 0, before: []string{""}, after: []string{line}})
+	return s, interrupts
+}
+
+func press(t *testing.T, s *State, notation string) {
+	t.Helper()
+	keys, err := parseKeys(notation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		s.HandleKey(k.event())
+	}
+}
+
+func mapKeys(t *testing.T, s *State, args ...string) {
+	t.Helper()
+	if err := cmdMap(s, args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveKeys(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		maps     [][]string
+		keys     string
+		line     string
+		x        int
+		pending  string
+		timedOut bool
+	}{
+		{
+			name: "single key",
+			keys: "x",
+			line: "bc",
+		},
+		{
+			name: "longer binding",
+			maps: [][]string{{"x x", "end-of-line"}},
+			keys: "x x",
+			line: "abc",
+			x:    2,
+		},
+		{
+			name:    "prefix waits",
+			maps:    [][]string{{"x x", "end-of-line"}},
+			keys:    "x",
+			line:    "abc",
+			pending: "x",
+		},
+		{
+			name:     "prefix times out",
+			maps:     [][]string{{"x x", "end-of-line"}},
+			keys:     "x",
+			line:     "bc",
+			timedOut: true,
+		},
+		{
+			name: "bound prefix is replayed",
+			maps: [][]string{{"x x", "end-of-line"}},
+			keys: "x l",
+			line: "bc",
+			x:    1,
+		},
+		{
+			name: "unbound prefix is dropped",
+			keys: "<Space> x",
+			line: "abc",
+		},
+		{
+			name: "unbound prefix is dropped with every key after it",
+			keys: "<Space> w x l",
+			line: "abc",
+			x:    1,
+		},
+		{
+			name:     "unbound prefix times out",
+			keys:     "<Space>",
+			line:     "abc",
+			timedOut: true,
+		},
+		{
+			name: "insert mode prefix is inserted",
+			maps: [][]string{{"insert", "j k", "normal"}},
+			keys: "a j x",
+			line: "jxabc",
+			x:    2,
+		},
+		{
+			name: "insert mode binding",
+			maps: [][]string{{"insert", "j k", "normal"}},
+			keys: "a j k x",
+			line: "bc",
+		},
+		{
+			name:     "insert mode prefix times out",
+			maps:     [][]string{{"insert", "j k", "normal"}},
+			keys:     "a j",
+			line:     "jabc",
+			x:        1,
+			timedOut: true,
+		},
+	} {
+		s, interrupts := keymapState(t, "abc")
+		for _, args := range test.maps {
+			mapKeys(t, s, args...)
+		}
+		press(t, s, test.keys)
+		if test.timedOut {
+			pump(t, interrupts, func() bool { return len(s.pending) == 0 })
+		}
+		if got := s.Text.Line(0); got != test.line {
+			t.Errorf("%v: line is %q, want %q", test.name, got, test.line)
+		}
+		if s.Cursor.X != test.x {
+			t.Errorf("%v: cursor at %v, want %v", test.name, s.Cursor.X, test.x)
+		}
+		if got := s.PendingKeys(); got != test.pending {
+			t.Errorf("%v: pending %q, want %q", test.name, got, test.pending)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// A key is a single key press, normalised so that each key press has one
+// representation. Runes never carry ModShift, since it's already reflected
+// in the rune, and control characters are letters with ModCtrl.
+//
+// Keys are written in a notation much like vim's: a character stands for
+// itself, and anything else is a name in angle brackets with optional
+// modifier prefixes, such as <Esc>, <C-w> or <S-Up>.
+type key struct {
+	k   tcell.Key
+	r   rune
+	mod tcell.ModMask
+}
+
+var keyNames = map[tcell.Key]string{
+	tcell.KeyEnter:      "CR",
+	tcell.KeyTab:        "Tab",
+	tcell.KeyEsc:        "Esc",
+	tcell.KeyBackspace2: "BS",
+	tcell.KeyDelete:     "Del",
+	tcell.KeyInsert:     "Ins",
+	tcell.KeyUp:         "Up",
+	tcell.KeyDown:       "Down",
+	tcell.KeyLeft:       "Left",
+	tcell.KeyRight:      "Right",
+	tcell.KeyHome:       "Home",
+	tcell.KeyEnd:        "End",
+	tcell.KeyPgUp:       "PgUp",
+	tcell.KeyPgDn:       "PgDn",
+}
+
+func init() {
+	for i := 0; i < 12; i++ {
+		keyNames[tcell.KeyF1+tcell.Key(i)] = fmt.Sprintf("F%v", i+1)
+	}
+}
+
+// keyAliases are alternative names for keys, in lower case.
+var keyAliases = map[string]string{
+	"enter":     "cr",
+	"return":    "cr",
+	"escape":    "esc",
+	"backspace": "bs",
+	"delete":    "del",
+	"insert":    "ins",
+	"pageup":    "pgup",
+	"pagedown":  "pgdn",
+}
+
+var modifierNames = []struct {
+	mod    tcell.ModMask
+	prefix string
+}{
+	{tcell.ModCtrl, "C-"},
+	{tcell.ModAlt, "A-"},
+	{tcell.ModMeta, "M-"},
+	{tcell.ModShift, "S-"},
+}
+
+func keyOf(e *tcell.EventKey) key {
+	mod := e.Modifiers()
+	switch k := e.Key(); {
+	case k == tcell.KeyRune:
+		return key{k: k, r: e.Rune(), mod: mod &^ tcell.ModShift}
+	case k == tcell.KeyBackspace && mod&tcell.ModCtrl == 0:
+		return key{k: tcell.KeyBackspace2, mod: mod}
+	case k >= tcell.KeyCtrlA && k <= tcell.KeyCtrlZ &&
+		(mod&tcell.ModCtrl != 0 || keyNames[k] == ""):
+		return key{
+			k:   tcell.KeyRune,
+			r:   rune('a' + k - tcell.KeyCtrlA),
+			mod: mod | tcell.ModCtrl,
+		}
+	default:
+		return key{k: k, mod: mod}
+	}
+}
+
+// event returns an event for a press of k.
+func (k key) event() *tcell.EventKey {
+	if k.k == tcell.KeyRune && k.mod&tcell.ModCtrl != 0 &&
+		k.r >= 'a' && k.r <= 'z' {
+		ctrl := tcell.KeyCtrlA + tcell.Key(k.r-'a')
+		return tcell.NewEventKey(ctrl, rune(ctrl), k.mod)
+	}
+	return tcell.NewEventKey(k.k, k.r, k.mod)
+}
+
+func (k key) String() string {
+	name := keyNames[k.k]
+	if k.k == tcell.KeyRune {
+		switch k.r {
+		case ' ':
+			name = "Space"
+		case '<':
+			name = "lt"
+		default:
+			name = string(k.r)
+		}
+	} else if name == "" {
+		name = tcell.KeyNames[k.k]
+	}
+	prefix := ""
+	for _, m := range modifierNames {
+		if k.mod&m.mod != 0 {
+			prefix += m.prefix
+		}
+	}
+	if prefix == "" && utf8.RuneCountInString(name) == 1 {
+		return name
+	}
+	return "<" + prefix + name + ">"
+}
+
+func parseKey(token string) (key, error) {
+	if r, size := utf8.DecodeRuneInString(token); size == len(token) {
+		return key{k: tcell.KeyRune, r: r}, nil
+	}
+	if len(token) < 3 || token[0] != '<' || token[len(token)-1] != '>' {
+		return key{}, fmt.Errorf("invalid key %q", token)
+	}
+	name := token[1 : len(token)-1]
+	var mod tcell.ModMask
+outer:
+	for len(name) > 2 && name[1] == '-' {
+		for _, m := range modifierNames {
+			if strings.EqualFold(name[:2], m.prefix) {
+				mod |= m.mod
+				name = name[2:]
+				continue outer
+			}
+		}
+		return key{}, fmt.Errorf("invalid modifier in key %q", token)
+	}
+	if r, size := utf8.DecodeRuneInString(name); size == len(name) {
+		if mod&tcell.ModShift != 0 {
+			r = unicode.ToUpper(r)
+		} else if mod&tcell.ModCtrl != 0 {
+			r = unicode.ToLower(r)
+		}
+		return key{k: tcell.KeyRune, r: r, mod: mod &^ tcell.ModShift}, nil
+	}
+	lower := strings.ToLower(name)
+	if alias, ok := keyAliases[lower]; ok {
+		lower = alias
+	}
+	switch lower {
+	case "space":
+		return key{k: tcell.KeyRune, r: ' ', mod: mod &^ tcell.ModShift}, nil
+	case "lt":
+		return key{k: tcell.KeyRune, r: '<', mod: mod &^ tcell.ModShift}, nil
+	}
+	for k, n := range keyNames {
+		if strings.ToLower(n) == lower {
+			return key{k: k, mod: mod}, nil
+		}
+	}
+	return key{}, fmt.Errorf("unknown key %q", token)
+}
+
+// parseKeys parses a sequence of keys separated by spaces, such as "g g" or
+// "<Space> q".
+func parseKeys(notation string) ([]key, error) {
+	var keys []key
+	for _, token := range strings.Fields(notation) {
+		k, err := parseKey(token)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %q", notation)
+	}
+	return keys, nil
+}
+
+func formatKeys(keys []key) string {
+	var tokens []string
+	for _, k := range keys {
+		tokens = append(tokens, k.String())
+	}
+	return strings.Join(tokens, " ")
+}
@@ -0,0 +1,54 @@
+package state
+
+import "testing"
+
+func TestParseKeys(t *testing.T) {
+	for _, test := range []struct {
+		notation, want string
+	}{
+		{"g g", "g g"},
+		{"<Space> w   s", "<Space> w s"},
+		{"<space> <SPACE>", "<Space> <Space>"},
+		{"<C-w>", "<C-w>"},
+		{"<c-W>", "<C-w>"},
+		{"<S-a>", "A"},
+		{"<S-Up>", "<S-Up>"},
+		{"<A-x> <M-C-x>", "<A-x> <C-M-x>"},
+		{"<Enter> <Return>", "<CR> <CR>"},
+		{"<Escape>", "<Esc>"},
+		{"<PageDown>", "<PgDn>"},
+		{"<lt> <", "<lt> <lt>"},
+		{"<F12>", "<F12>"},
+		{"é", "é"},
+	} {
+		keys, err := parseKeys(test.notation)
+		if err != nil {
+			t.Errorf("%q: %v", test.notation, err)
+			continue
+		}
+		if got := formatKeys(keys); got != test.want {
+			t.Errorf("%q formats as %q, want %q", test.notation, got, test.want)
+		}
+		// The canonical notation parses to the same keys, and so do the
+		// key presses they stand for.
+		again, err := parseKeys(test.want)
+		if err != nil || formatKeys(again) != test.want {
+			t.Errorf("%q reparses as %q, %v", test.want, formatKeys(again), err)
+		}
+		for i, k := range keys {
+			if got := keyOf(k.event()); got != k {
+				t.Errorf("%q: key %v is pressed as %v", test.notation, i, got)
+			}
+		}
+	}
+}
+
+func TestParseKeysErrors(t *testing.T) {
+	for _, notation := range []string{
+		"", "  ", "ab", "<>", "<Foo>", "<X-a>", "<C-", "g <Nope>",
+	} {
+		if keys, err := parseKeys(notation); err == nil {
+			t.Errorf("%q parses as %q", notation, formatKeys(keys))
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/callum-oakley/vee/lsp"
+)
+
+// languageServers maps language names to the commands that run their
+// language servers. A server is started, in the working directory, when the
+// first file in its language is opened.
+var languageServers = map[string][]string{
+	"go": {"gopls"},
+}
+
+// A Diagnostic is a problem in the text reported by a language server.
+type Diagnostic struct {
+	From, To cursor
+	Severity lsp.DiagnosticSeverity
+	Message  string
+}
+
+func init() {
+	RegisterCommand("language-server", cmdLanguageServer)
+	RegisterCommand("definition", cmdDefinition)
+	RegisterCommand("hover", cmdHover)
+	RegisterCommand("rename", cmdRename)
+	RegisterCommand("diagnostics", cmdDiagnostics)
+}
+
+// interrupt runs f on the goroutine handling keys, if the editor has a way
+// of doing so, and otherwise drops it.
+func (s *State) interrupt(f func()) {
+	if s.Interrupt != nil {
+		s.Interrupt(f)
+	}
+}
+
+// contents returns the text of b as language servers see it, with every line
+// followed by a newline whatever the format of the file.
+func (b *Buffer) contents() string {
+	var sb strings.Builder
+	for y := 0; y < b.Text.Len(); y++ {
+		sb.WriteString(b.Text.Line(y))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// attachLanguageServer opens b with the language server for its language,
+// starting the server if it isn't running yet, and keeps the server in sync
+// with changes to b. Languages whose servers aren't installed are ignored.
+//
+// A server is started without waiting for it, so that a slow one doesn't
+// freeze the editor, and buffers opened in the meantime wait along with the
+// first. A server that fails to start is tried again with the next buffer
+// in its language.
+func (s *State) attachLanguageServer(b *Buffer) {
+	if b.Highlighter == nil {
+		return
+	}
+	lang := b.Highlighter.Language.Name
+	command, ok := languageServers[lang]
+	if !ok {
+		return
+	}
+	if c, ok := s.servers[lang]; ok {
+		b.openWith(c, lang)
+		return
+	}
+	if waiting, ok := s.starting[lang]; ok {
+		s.starting[lang] = append(waiting, b)
+		return
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		s.Msg = err.Error()
+		return
+	}
+	if s.starting == nil {
+		s.starting = map[string][]*Buffer{}
+	}
+	s.starting[lang] = []*Buffer{b}
+	s.request(func() func() error {
+		c, err := lsp.Start(command, root, s.publishDiagnostics)
+		return func() error {
+			waiting := s.starting[lang]
+			delete(s.starting, lang)
+			if err != nil {
+				return fmt.Errorf("%v: %v", command[0], err)
+			}
+			if s.servers == nil {
+				s.servers = map[string]*lsp.Client{}
+			}
+			s.servers[lang] = c
+			for _, b := range waiting {
+				b.openWith(c, lang)
+			}
+			return nil
+		}
+	})
+}
+
+// openWith opens b, whose language is lang, with the language server c.
+func (b *Buffer) openWith(c *lsp.Client, lang string) {
+	b.server = c
+	c.DidOpen(lsp.URI(b.FilePath), lang, b.version, b.contents())
+}
+
+// detachLanguageServer closes b with its language server, if it has one, or
+// stops it waiting for one to start.
+func (s *State) detachLanguageServer(b *Buffer) {
+	for lang, waiting := range s.starting {
+		for i, w := range waiting {
+			if w == b {
+				s.starting[lang] = append(waiting[:i:i], waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	if b.server == nil {
+		return
+	}
+	b.server.DidClose(lsp.URI(b.FilePath))
+	b.server = nil
+	b.Diagnostics = nil
+}
+
+// didChange tells b's language server, if it has one, about d.
+func (b *Buffer) didChange(d diff) {
+	if b.server == nil {
+		return
+	}
+	b.version++
+	change := lsp.ContentChange{Text: strings.Join(d.after, "\n")}
+	if len(d.after) > 0 {
+		change.Text += "\n"
+	}
+	if b.server.Incremental() {
+		change.Range = &lsp.Range{
+			Start: lsp.Position{Line: d.start},
+			End:   lsp.Position{Line: d.start + len(d.before)},
+		}
+	} else {
+		change.Text = b.contents()
+	}
+	b.server.DidChange(
+		lsp.URI(b.FilePath), b.version, []lsp.ContentChange{change},
+	)
+}
+
+// publishDiagnostics is called by language servers, from their own
+// goroutines, to replace the diagnostics for the file at uri.
+func (s *State) publishDiagnostics(uri string, diagnostics []lsp.Diagnostic) {
+	s.interrupt(func() {
+		i := s.findBuffer(lsp.Path(uri))
+		if i < 0 {
+			return
+		}
+		b := s.Buffers[i]
+		b.Diagnostics = nil
+		for _, d := range diagnostics {
+			from := b.position(d.Range.Start)
+			to := b.position(d.Range.End)
+			if from == to {
+				to.X++
+			}
+			b.Diagnostics = append(b.Diagnostics, Diagnostic{
+				From:     from,
+				To:       to,
+				Severity: d.Severity,
+				Message:  d.Message,
+			})
+		}
+		sort.SliceStable(b.Diagnostics, func(i, j int) bool {
+			return before(b.Diagnostics[i].From, b.Diagnostics[j].From)
+		})
+	})
+}
+
+// shiftDiagnostics keeps b's diagnostics with the text they were reported
+// for, until the language server reports them again, after d has been
+// applied. Diagnostics on lines that were deleted go with them.
+func (b *Buffer) shiftDiagnostics(d diff) {
+	end := d.start + len(d.before)
+	shift := func(c *cursor) {
+		if c.Y >= end {
+			c.Y += len(d.after) - len(d.before)
+		} else if c.Y >= d.start {
+			c.Y = min(c.Y, d.start+len(d.after)-1)
+			c.X = min(c.X, len(b.Text.Line(c.Y)))
+		}
+	}
+	var diagnostics []Diagnostic
+	for _, diagnostic := range b.Diagnostics {
+		if len(d.after) == 0 && diagnostic.From.Y >= d.start &&
+			diagnostic.From.Y < end {
+			continue
+		}
+		shift(&diagnostic.From)
+		shift(&diagnostic.To)
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	b.Diagnostics = diagnostics
+}
+
+// position converts pos to a cursor in b, without a column. A position past
+// the last line is at the start of the line after it.
+func (b *Buffer) position(pos lsp.Position) cursor {
+	if pos.Line >= b.Text.Len() {
+		return cursor{Y: b.Text.Len()}
+	}
+	line := b.Text.Line(pos.Line)
+	if b.server == nil {
+		return cursor{X: min(pos.Character, len(line)), Y: pos.Line}
+	}
+	return cursor{X: b.server.Offset(line, pos.Character), Y: pos.Line}
+}
+
+// cursorPosition returns the position of the primary cursor as understood
+// by the current buffer's language server.
+func (s *State) cursorPosition() (lsp.Position, error) {
+	if s.server == nil {
+		return lsp.Position{}, errors.New("no language server")
+	}
+	line := s.Text.Line(s.Cursor.Y)
+	return lsp.Position{
+		Line:      s.Cursor.Y,
+		Character: s.server.Character(line, max(0, s.Cursor.X)),
+	}, nil
+}
+
+// LineDiagnostic returns the first line of the message of the first
+// diagnostic on the primary cursor's line, if there is one.
+func (s *State) LineDiagnostic() string {
+	for _, d := range s.Diagnostics {
+		if d.From.Y <= s.Cursor.Y && d.To.Y >= s.Cursor.Y {
+			return strings.SplitN(d.Message, "\n", 2)[0]
+		}
+	}
+	return ""
+}
+
+// DiagnosticStatus counts b's errors and warnings, or is empty if there are
+// none.
+func (b *Buffer) DiagnosticStatus() string {
+	errs, warnings := 0, 0
+	for _, d := range b.Diagnostics {
+		switch d.Severity {
+		case lsp.SeverityError:
+			errs++
+		case lsp.SeverityWarning:
+			warnings++
+		}
+	}
+	var status []string
+	if errs > 0 {
+		status = append(status, fmt.Sprintf("E%v", errs))
+	}
+	if warnings > 0 {
+		status = append(status, fmt.Sprintf("W%v", warnings))
+	}
+	return strings.Join(status, " ")
+}
+
+// jumpTo moves the primary cursor to c and drops the other selections.
+func (s *State) jumpTo(c cursor) {
+	s.keepPrimarySelection()
+	s.move(func(cur *cursor) {
+		y := max(0, min(c.Y, s.Text.Len()-1))
+		line := s.Text.Line(y)
+		*cur = s.cursorAt(y, line, min(max(0, c.X), len(line)))
+	})
+}
+
+// CloseLanguageServers shuts down every running language server.
+func (s *State) CloseLanguageServers() {
+	for _, c := range s.servers {
+		if c != nil {
+			c.Close()
+		}
+	}
+	s.servers = nil
+}
+
+func cmdLanguageServer(s *State, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: language-server language command...")
+	}
+	languageServers[args[0]] = args[1:]
+	return nil
+}
+
+// request asks a language server for something with call, without waiting
+// for the answer if the editor has a way of hearing back later, so that a
+// slow server doesn't freeze it. The function call returns is run with the
+// answer on the goroutine handling keys, and any error it returns is shown.
+func (s *State) request(call func() func() error) {
+	if s.Interrupt == nil {
+		s.report(call()())
+		return
+	}
+	go func() {
+		done := call()
+		s.Interrupt(func() { s.report(done()) })
+	}()
+}
+
+func cmdDefinition(s *State, args []string) error {
+	pos, err := s.cursorPosition()
+	if err != nil {
+		return err
+	}
+	c, uri := s.server, lsp.URI(s.FilePath)
+	s.request(func() func() error {
+		locations, err := c.Definition(uri, pos)
+		return func() error {
+			if err != nil {
+				return err
+			}
+			if len(locations) == 0 {
+				return errors.New("no definition found")
+			}
+			if err := s.Open(lsp.Path(locations[0].URI)); err != nil {
+				return err
+			}
+			s.jumpTo(s.position(locations[0].Range.Start))
+			return nil
+		}
+	})
+	return nil
+}
+
+// cmdHover shows the documentation for the symbol under the primary cursor,
+// unless the cursor or the text has moved on by the time it arrives.
+func cmdHover(s *State, args []string) error {
+	pos, err := s.cursorPosition()
+	if err != nil {
+		return err
+	}
+	c, uri := s.server, lsp.URI(s.FilePath)
+	b, version, cur := s.Buffer, s.version, s.Cursor
+	s.request(func() func() error {
+		text, err := c.Hover(uri, pos)
+		return func() error {
+			if s.Buffer != b || b.version != version || s.Cursor != cur {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			text = strings.TrimSpace(text)
+			if text == "" {
+				return errors.New("nothing to show")
+			}
+			s.startMenu(strings.Split(text, "\n"), -1, func(int) {})
+			return nil
+		}
+	})
+	return nil
+}
+
+// cmdRename renames the symbol under the primary cursor everywhere the
+// language server knows of. The edits are only good for the text they were
+// made for, so if the buffer changes while waiting for them, they're
+// dropped.
+func cmdRename(s *State, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rename name")
+	}
+	pos, err := s.cursorPosition()
+	if err != nil {
+		return err
+	}
+	c, uri := s.server, lsp.URI(s.FilePath)
+	b, version := s.Buffer, s.version
+	s.request(func() func() error {
+		edit, err := c.Rename(uri, pos, args[0])
+		return func() error {
+			if err != nil {
+				return err
+			}
+			if b.version != version {
+				return errors.New("the text changed before the rename arrived")
+			}
+			current := s.Buffer
+			edits := edit.Edits()
+			for uri, es := range edits {
+				if err := s.Open(lsp.Path(uri)); err != nil {
+					s.Buffer = current
+					return err
+				}
+				s.applyTextEdits(es)
+			}
+			s.Buffer = current
+			if len(edits) == 1 {
+				s.Msg = "renamed in 1 file"
+			} else {
+				s.Msg = fmt.Sprintf("renamed in %v files", len(edits))
+			}
+			return nil
+		}
+	})
+	return nil
+}
+
+// applyTextEdits applies edits from a language server to the current buffer
+// as a single change.
+func (s *State) applyTextEdits(edits []lsp.TextEdit) {
+	type edit struct {
+		from, to cursor
+		text     string
+	}
+	var es []edit
+	for _, e := range edits {
+		from, to := s.position(e.Range.Start), s.position(e.Range.End)
+		es = append(es, edit{from, to, e.NewText})
+	}
+	// Apply later edits first so the positions of earlier ones stay valid.
+	sort.SliceStable(es, func(i, j int) bool {
+		return before(es[j].from, es[i].from)
+	})
+	s.startChange()
+	sels := s.Selections()
+	s.visited = sels
+	for _, e := range es {
+		end := min(e.to.Y+1, s.Text.Len())
+		var prefix, suffix string
+		if e.from.Y < s.Text.Len() {
+			prefix = s.Text.Line(e.from.Y)[:e.from.X]
+		}
+		if e.to.Y < s.Text.Len() {
+			suffix = s.Text.Line(e.to.Y)[e.to.X:]
+		}
+		text := prefix + e.text + suffix
+		if e.to.Y >= s.Text.Len() {
+			text = strings.TrimSuffix(text, "\n")
+		}
+		s.applyDiff(diff{
+			start:  min(e.from.Y, s.Text.Len()),
+			before: s.Text.Lines(min(e.from.Y, end), end),
+			after:  strings.Split(text, "\n"),
+		})
+	}
+	s.visited = nil
+	s.setSelections(sels)
+	s.mergeSelections()
+	s.endChange()
+}
+
+func cmdDiagnostics(s *State, args []string) error {
+	if len(s.Diagnostics) == 0 {
+		return errors.New("no diagnostics")
+	}
+	var items []string
+	for _, d := range s.Diagnostics {
+		items = append(items, fmt.Sprintf(
+			"%v:%v %v", d.From.Y+1, d.From.X+1,
+			strings.SplitN(d.Message, "\n", 2)[0],
+		))
+	}
+	diagnostics := s.Diagnostics
+	s.startMenu(items, 0, func(i int) {
+		s.jumpTo(diagnostics[i].From)
+	})
+	return nil
+}
@@ -0,0 +1,226 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/callum-oakley/vee/lsp"
+	"github.com/callum-oakley/vee/lsp/lsptest"
+)
+
+// The test binary stands in for a language server when it's run with
+// serverEnv set.
+const serverEnv = "VEE_LSPTEST_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(serverEnv) != "" {
+		lsptest.Serve(os.Stdin, os.Stdout)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// lspState returns a state in a fresh working directory, with the test
+// binary as the language server for Go. Functions passed to Interrupt are
+// queued until pump runs them.
+func lspState(t *testing.T) (*State, chan func()) {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	os.Setenv(serverEnv, "1")
+	command := languageServers["go"]
+	languageServers["go"] = []string{os.Args[0]}
+	interrupts := make(chan func(), 64)
+	s := &State{
+		TabWidth:  4,
+		Interrupt: func(f func()) { interrupts <- f },
+	}
+	t.Cleanup(func() {
+		s.CloseLanguageServers()
+		languageServers["go"] = command
+		os.Unsetenv(serverEnv)
+		os.Unsetenv("XDG_STATE_HOME")
+		os.Chdir(wd)
+	})
+	return s, interrupts
+}
+
+// open writes text to the file at path and opens it.
+func open(t *testing.T, s *State, path, text string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(text), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serve opens a Go file containing text, and waits for its language server
+// to start.
+func serve(t *testing.T, text string) (*State, chan func()) {
+	t.Helper()
+	s, interrupts := lspState(t)
+	open(t, s, "a.go", text)
+	pump(t, interrupts, func() bool { return s.server != nil || s.Msg != "" })
+	if s.server == nil {
+		t.Fatalf("no language server: %v", s.Msg)
+	}
+	return s, interrupts
+}
+
+// pump runs interrupts until done returns true.
+func pump(t *testing.T, interrupts chan func(), done func() bool) {
+	t.Helper()
+	timeout := time.After(lsp.Timeout)
+	for !done() {
+		select {
+		case f := <-interrupts:
+			f()
+		case <-timeout:
+			t.Fatal("timed out")
+		}
+	}
+}
+
+func edit(s *State, d diff) {
+	s.startChange()
+	s.applyDiff(d)
+	s.endChange()
+}
+
+func diagnosticLines(s *State) []int {
+	var lines []int
+	for _, d := range s.Diagnostics {
+		lines = append(lines, d.From.Y)
+	}
+	return lines
+}
+
+func TestDiagnostics(t *testing.T) {
+	s, interrupts := serve(t, "package a\n\nbad\nwarn\n")
+	pump(t, interrupts, func() bool { return len(s.Diagnostics) == 2 })
+	want := []Diagnostic{
+		{cursor{X: 0, Y: 2}, cursor{X: 3, Y: 2}, lsp.SeverityError, "bad"},
+		{cursor{X: 0, Y: 3}, cursor{X: 4, Y: 3}, lsp.SeverityWarning, "warn"},
+	}
+	if !reflect.DeepEqual(s.Diagnostics, want) {
+		t.Errorf("got %+v, want %+v", s.Diagnostics, want)
+	}
+
+	// Until the server reports them again, diagnostics follow their lines.
+	edit(s, diff{start: 1, after: []string{"x", "y"}})
+	if got := diagnosticLines(s); !reflect.DeepEqual(got, []int{4, 5}) {
+		t.Errorf("after inserting lines, diagnostics are on %v", got)
+	}
+	edit(s, diff{start: 1, before: []string{"x", "y", ""}})
+	if got := diagnosticLines(s); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("after deleting lines, diagnostics are on %v", got)
+	}
+	edit(s, diff{start: 1, before: []string{"bad"}})
+	if got := diagnosticLines(s); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("after deleting bad's line, diagnostics are on %v", got)
+	}
+
+	// The server has been kept in sync, and agrees.
+	pump(t, interrupts, func() bool {
+		return len(s.Diagnostics) == 1 && s.Diagnostics[0].Message == "warn"
+	})
+	if got := diagnosticLines(s); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("the server reports diagnostics on %v", got)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	s, interrupts := serve(t, "package a\n\nfunc f() {}\n\nfunc g() { f() }\n")
+	s.jumpTo(cursor{X: 11, Y: 4})
+	s.report(s.runCommand("definition"))
+	if s.Msg != "" || s.Cursor.Y != 4 {
+		t.Fatalf("definition should wait for the server, got %q", s.Msg)
+	}
+	pump(t, interrupts, func() bool { return s.Cursor.Y != 4 || s.Msg != "" })
+	if s.Cursor.X != 5 || s.Cursor.Y != 2 {
+		t.Errorf("got %+v %q, want the cursor on 2:5", s.Cursor, s.Msg)
+	}
+}
+
+func TestHover(t *testing.T) {
+	s, interrupts := serve(t, "package a\n\nfunc f() {}\n")
+	s.jumpTo(cursor{X: 5, Y: 2})
+	s.report(s.runCommand("hover"))
+	pump(t, interrupts, func() bool { return s.mode == modeMenu })
+	if want := []string{"about f"}; !reflect.DeepEqual(s.menu.items, want) {
+		t.Errorf("got %q, want %q", s.menu.items, want)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s, interrupts := serve(t, "package a\n\nfunc f() {}\n\nfunc g() { f() }\n")
+	s.jumpTo(cursor{X: 5, Y: 2})
+	s.report(s.runCommand("rename h"))
+	pump(t, interrupts, func() bool { return s.Msg != "" })
+	if s.Msg != "renamed in 1 file" {
+		t.Fatal(s.Msg)
+	}
+	want := []string{"package a", "", "func h() {}", "", "func g() { h() }"}
+	if got := s.Text.Lines(0, s.Text.Len()); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRenameAfterChange(t *testing.T) {
+	s, interrupts := serve(t, "package a\n\nfunc f() {}\n")
+	s.jumpTo(cursor{X: 5, Y: 2})
+	s.report(s.runCommand("rename h"))
+	edit(s, diff{start: 1, after: []string{"// f"}})
+	pump(t, interrupts, func() bool { return s.Msg != "" })
+	if s.Msg != "the text changed before the rename arrived" {
+		t.Error(s.Msg)
+	}
+	if got := s.Text.Line(3); got != "func f() {}" {
+		t.Errorf("got %q, want the rename dropped", got)
+	}
+}
+
+func TestServerStartsInBackground(t *testing.T) {
+	s, interrupts := lspState(t)
+	open(t, s, "a.go", "package a\n\nbad\n")
+	open(t, s, "b.go", "package a\n\nwarn\n")
+	a, b := s.Buffers[0], s.Buffers[1]
+	if a.server != nil || b.server != nil {
+		t.Fatal("opening a file waited for its language server")
+	}
+	// Both buffers are opened with the server once it's started.
+	pump(t, interrupts, func() bool {
+		return len(a.Diagnostics) == 1 && len(b.Diagnostics) == 1
+	})
+	if a.server == nil || a.server != b.server {
+		t.Error("the buffers should share a server")
+	}
+}
+
+func TestServerFailsToStart(t *testing.T) {
+	s, interrupts := lspState(t)
+	languageServers["go"] = []string{"false"}
+	open(t, s, "a.go", "package a\n")
+	pump(t, interrupts, func() bool { return s.Msg != "" })
+	if s.server != nil || !strings.HasPrefix(s.Msg, "false: ") {
+		t.Fatalf("got %v, %q, want a failure to start", s.server, s.Msg)
+	}
+	// The server is tried again with the next file.
+	languageServers["go"] = []string{os.Args[0]}
+	open(t, s, "b.go", "package a\n")
+	pump(t, interrupts, func() bool { return s.server != nil })
+}
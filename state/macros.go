@@ -0,0 +1,164 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// maxMacroDepth limits how deeply macros can play other macros, so that a
+// macro which plays itself stops eventually.
+const maxMacroDepth = 100
+
+// macrosFile holds every recorded macro, in the state directory, as a map
+// from slot to keys in key notation.
+const macrosFile = "macros"
+
+// A recording is a macro being recorded.
+type recording struct {
+	slot string
+	keys []key
+}
+
+func init() {
+	// These refer back to actions through playMacro, so they can't be part
+	// of its initialiser.
+	actions["record-macro"] = (*State).toggleRecording
+	actions["play-macro"] = (*State).playMacroFromKey
+	RegisterCommand("play", cmdPlay)
+	RegisterCommand("macros", cmdMacros)
+}
+
+// loadMacros reads the recorded macros from the state directory, the first
+// time they're needed.
+func (s *State) loadMacros() {
+	if s.macros != nil {
+		return
+	}
+	s.macros = map[string][]key{}
+	var saved map[string]string
+	if err := readStateFile(macrosFile, &saved); err != nil {
+		s.Msg = "couldn't load macros: " + err.Error()
+		return
+	}
+	for slot, notation := range saved {
+		if keys, err := parseKeys(notation); err == nil {
+			s.macros[slot] = keys
+		}
+	}
+}
+
+func (s *State) saveMacros() error {
+	saved := map[string]string{}
+	for slot, keys := range s.macros {
+		saved[slot] = formatKeys(keys)
+	}
+	return writeStateFile(macrosFile, saved)
+}
+
+// toggleRecording starts recording a macro into the slot named by the next
+// key, or if a macro is already being recorded, stops and saves it.
+func (s *State) toggleRecording() {
+	if s.recording != nil {
+		r := s.recording
+		s.recording = nil
+		// The keys that stopped the recording aren't part of the macro.
+		r.keys = r.keys[:max(0, len(r.keys)-len(s.lastSequence))]
+		s.loadMacros()
+		s.macros[r.slot] = r.keys
+		if err := s.saveMacros(); err != nil {
+			s.Msg = "couldn't save macros: " + err.Error()
+		}
+		return
+	}
+	s.awaitKey(func(k key) {
+		if k.k != tcell.KeyEsc {
+			s.recording = &recording{slot: k.String()}
+		}
+	})
+}
+
+// Recording returns the slot of the macro being recorded, if there is one.
+func (s *State) Recording() (string, bool) {
+	if s.recording == nil {
+		return "", false
+	}
+	return s.recording.slot, true
+}
+
+// playMacro plays the macro in slot n times, as a single change.
+func (s *State) playMacro(slot string, n int) error {
+	s.loadMacros()
+	keys, ok := s.macros[slot]
+	if !ok {
+		return fmt.Errorf("no macro in %v", slot)
+	}
+	if s.macroDepth >= maxMacroDepth {
+		return errors.New("macros nested too deeply")
+	}
+	s.macroDepth++
+	defer func() { s.macroDepth-- }()
+	s.beginGroup()
+	defer s.endGroup()
+	// Keys pending from before the macro started are handled on their own,
+	// rather than as the start of a sequence continued by the macro.
+	pending := s.pending
+	s.pending = nil
+	for i := 0; i < n && !s.quit; i++ {
+		for _, k := range keys {
+			s.pending = append(s.pending, k)
+			s.resolveKeys(false)
+		}
+	}
+	s.resolveKeys(true)
+	s.pending = pending
+	return nil
+}
+
+func (s *State) playMacroFromKey() {
+	s.awaitKey(func(k key) {
+		if k.k != tcell.KeyEsc {
+			s.report(s.playMacro(k.String(), 1))
+		}
+	})
+}
+
+func cmdPlay(s *State, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: play slot [count]")
+	}
+	n := 1
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	return s.playMacro(args[0], n)
+}
+
+// cmdMacros lists the recorded macros in a menu, and plays whichever is
+// picked.
+func cmdMacros(s *State, args []string) error {
+	s.loadMacros()
+	if len(s.macros) == 0 {
+		return errors.New("no macros")
+	}
+	var slots []string
+	for slot := range s.macros {
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+	var items []string
+	for _, slot := range slots {
+		items = append(items, slot+"  "+formatKeys(s.macros[slot]))
+	}
+	s.startMenu(items, 0, func(i int) {
+		s.report(s.playMacro(slots[i], 1))
+	})
+	return nil
+}
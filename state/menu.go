@@ -0,0 +1,47 @@
+package state
+
+import "github.com/gdamore/tcell/v2"
+
+type menu struct {
+	items    []string
+	selected int
+	pick     func(int)
+}
+
+// Menu returns the items of the open menu and the index of the selected
+// item.
+func (s *State) Menu() ([]string, int, bool) {
+	if s.mode != modeMenu {
+		return nil, 0, false
+	}
+	return s.menu.items, s.menu.selected, true
+}
+
+func (s *State) startMenu(items []string, selected int, pick func(int)) {
+	s.menu = menu{items: items, selected: selected, pick: pick}
+	s.mode = modeMenu
+}
+
+func (s *State) handleMenuKey(e *tcell.EventKey) {
+	switch e.Key() {
+	case tcell.KeyRune:
+		switch r := e.Rune(); {
+		case r == 'k':
+			s.menu.selected = max(0, s.menu.selected-1)
+		case r == 'j':
+			s.menu.selected = min(len(s.menu.items)-1, s.menu.selected+1)
+		case r >= '1' && r <= '9' && int(r-'1') < len(s.menu.items):
+			s.mode = modeNormal
+			s.menu.pick(int(r - '1'))
+		}
+	case tcell.KeyUp:
+		s.menu.selected = max(0, s.menu.selected-1)
+	case tcell.KeyDown:
+		s.menu.selected = min(len(s.menu.items)-1, s.menu.selected+1)
+	case tcell.KeyCR:
+		s.mode = modeNormal
+		s.menu.pick(s.menu.selected)
+	case tcell.KeyESC:
+		s.mode = modeNormal
+	}
+}
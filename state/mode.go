@@ -18,10 +18,12 @@ func (s *State) setMode(m mode) {
 	}
 	switch m {
 	case modeInsert:
-		if s.Cursor.X == -1 {
-			s.setCursorX(&s.Cursor, 0)
-		}
-		s.Anchor = s.Cursor
+		s.forEachSelection(func() {
+			if s.Cursor.X == -1 {
+				s.setCursorX(&s.Cursor, 0)
+			}
+			s.Anchor = s.Cursor
+		})
 		setCursorShape(3)
 	}
 	s.mode = m
@@ -17,7 +17,7 @@ func visualWidth(col, tabWidth int, char rune) int {
 func (s *State) setCursorX(c *cursor, x int) {
 	c.X = x
 	c.col = 0
-	for _, char := range s.Text[c.Y][:c.X] {
+	for _, char := range s.Text.Line(c.Y)[:c.X] {
 		c.col += visualWidth(c.col, s.TabWidth, char)
 	}
 }
@@ -27,7 +27,7 @@ func (s *State) setCursorY(c *cursor, y int) {
 	c.X = -1
 	col := 0
 	var char rune
-	for c.X, char = range s.Text[c.Y] {
+	for c.X, char = range s.Text.Line(c.Y) {
 		col += visualWidth(col, s.TabWidth, char)
 		if col > c.col {
 			break
@@ -36,12 +36,12 @@ func (s *State) setCursorY(c *cursor, y int) {
 }
 
 func (s *State) xLeftOf(c *cursor) int {
-	_, size := utf8.DecodeLastRuneInString(s.Text[c.Y][:c.X])
+	_, size := utf8.DecodeLastRuneInString(s.Text.Line(c.Y)[:c.X])
 	return c.X - size
 }
 
 func (s *State) xRightOf(c *cursor) int {
-	_, size := utf8.DecodeRuneInString(s.Text[c.Y][c.X:])
+	_, size := utf8.DecodeRuneInString(s.Text.Line(c.Y)[c.X:])
 	return c.X + size
 }
 
@@ -53,11 +53,11 @@ func (s *State) moveLeft(c *cursor) {
 }
 
 func (s *State) moveRight(c *cursor) {
-	if len(s.Text[c.Y]) == 0 {
+	if len(s.Text.Line(c.Y)) == 0 {
 		return
 	}
-	_, sizeLast := utf8.DecodeLastRuneInString(s.Text[c.Y])
-	if c.X == len(s.Text[c.Y])-sizeLast {
+	_, sizeLast := utf8.DecodeLastRuneInString(s.Text.Line(c.Y))
+	if c.X == len(s.Text.Line(c.Y))-sizeLast {
 		return
 	}
 	s.setCursorX(c, s.xRightOf(c))
@@ -75,18 +75,18 @@ func (s *State) moveUp(c *cursor, n int) {
 }
 
 func (s *State) moveDown(c *cursor, n int) {
-	if c.Y == len(s.Text)-1 {
+	if c.Y == s.Text.Len()-1 {
 		return
 	}
-	if c.Y+n <= len(s.Text)-1 {
+	if c.Y+n <= s.Text.Len()-1 {
 		s.setCursorY(c, c.Y+n)
 	} else {
-		s.setCursorY(c, len(s.Text)-1)
+		s.setCursorY(c, s.Text.Len()-1)
 	}
 }
 
 func (s *State) moveStartOfLine(c *cursor) {
-	for x, char := range s.Text[c.Y] {
+	for x, char := range s.Text.Line(c.Y) {
 		if char != ' ' && char != '\t' {
 			s.setCursorX(c, x)
 			return
@@ -95,11 +95,11 @@ func (s *State) moveStartOfLine(c *cursor) {
 }
 
 func (s *State) moveEndOfLine(c *cursor) {
-	if len(s.Text[c.Y]) == 0 {
+	if len(s.Text.Line(c.Y)) == 0 {
 		return
 	}
-	_, sizeLast := utf8.DecodeLastRuneInString(s.Text[c.Y])
-	s.setCursorX(c, len(s.Text[c.Y])-sizeLast)
+	_, sizeLast := utf8.DecodeLastRuneInString(s.Text.Line(c.Y))
+	s.setCursorX(c, len(s.Text.Line(c.Y))-sizeLast)
 }
 
 var reStartOfWord = regexp.MustCompile(
@@ -107,11 +107,11 @@ var reStartOfWord = regexp.MustCompile(
 )
 
 func (s *State) moveStartOfWord(c *cursor) {
-	if len(s.Text[c.Y]) == 0 {
+	if len(s.Text.Line(c.Y)) == 0 {
 		return
 	}
 	if match := reStartOfWord.FindStringIndex(
-		s.Text[c.Y][:c.X],
+		s.Text.Line(c.Y)[:c.X],
 	); match != nil {
 		s.setCursorX(c, match[0])
 	}
@@ -122,11 +122,11 @@ var reEndOfWord = regexp.MustCompile(
 )
 
 func (s *State) moveEndOfWord(c *cursor) {
-	if len(s.Text[c.Y]) == 0 {
+	if len(s.Text.Line(c.Y)) == 0 {
 		return
 	}
 	if match := reEndOfWord.FindStringIndex(
-		s.Text[c.Y][s.xRightOf(c):],
+		s.Text.Line(c.Y)[s.xRightOf(c):],
 	); match != nil {
 		s.setCursorX(c, c.X+match[1])
 	}
@@ -134,5 +134,17 @@ func (s *State) moveEndOfWord(c *cursor) {
 
 func (s *State) move(f func(*cursor)) {
 	f(&s.Cursor)
-	s.Anchor = s.Cursor
+	for i := range s.Secondary {
+		f(&s.Secondary[i].Cursor)
+	}
+	s.collapseSelections()
+	s.mergeSelections()
+}
+
+func (s *State) extend(f func(*cursor)) {
+	f(&s.Cursor)
+	for i := range s.Secondary {
+		f(&s.Secondary[i].Cursor)
+	}
+	s.mergeSelections()
 }
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/callum-oakley/vee/syntax"
+)
+
+type option struct {
+	get func(s *State) string
+	set func(s *State, value string) error
+}
+
+var options = map[string]option{}
+
+func registerOption(
+	name string,
+	get func(s *State) string,
+	set func(s *State, value string) error,
+) {
+	options[name] = option{get: get, set: set}
+}
+
+func init() {
+	registerOption(
+		"tabwidth",
+		func(s *State) string { return strconv.Itoa(s.TabWidth) },
+		func(s *State, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid tab width %q", value)
+			}
+			s.TabWidth = n
+			return nil
+		},
+	)
+	registerOption(
+		"syntax",
+		func(s *State) string {
+			if s.Buffer == nil || s.Highlighter == nil {
+				return "none"
+			}
+			return s.Highlighter.Language.Name
+		},
+		func(s *State, value string) error {
+			if s.Buffer == nil {
+				return errors.New("no buffer")
+			}
+			if value == "none" {
+				s.setLanguage(nil)
+				return nil
+			}
+			lang := syntax.Find(value)
+			if lang == nil {
+				return fmt.Errorf("unknown syntax %q", value)
+			}
+			s.setLanguage(lang)
+			return nil
+		},
+	)
+}
+
+// cmdSet sets an option, or shows its value if no value is given, or shows
+// every option if no name is given.
+func cmdSet(s *State, args []string) error {
+	switch len(args) {
+	case 0:
+		var names []string
+		for name := range options {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var values []string
+		for _, name := range names {
+			values = append(values, name+"="+options[name].get(s))
+		}
+		s.Msg = strings.Join(values, " ")
+		return nil
+	case 1, 2:
+		o, ok := options[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown option %q", args[0])
+		}
+		if len(args) == 1 {
+			s.Msg = args[0] + "=" + o.get(s)
+			return nil
+		}
+		return o.set(s, args[1])
+	default:
+		return errors.New("usage: set [option [value]]")
+	}
+}
@@ -0,0 +1,91 @@
+package state
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+type prompt struct {
+	prefix, text string
+	submit       func(string)
+	// complete returns the possible completions of text, if completion is
+	// supported.
+	complete    func(text string) []string
+	completions []string
+	completion  int
+}
+
+// Prompt returns the contents of the message line while the user is typing
+// into a prompt.
+func (s *State) Prompt() (string, bool) {
+	if s.mode != modePrompt {
+		return "", false
+	}
+	return s.prompt.prefix + s.prompt.text, true
+}
+
+func (s *State) startPrompt(prefix string, submit func(string)) {
+	s.prompt = prompt{prefix: prefix, submit: submit}
+	s.mode = modePrompt
+}
+
+func (s *State) handlePromptKey(e *tcell.EventKey) {
+	if e.Key() != tcell.KeyTAB {
+		s.prompt.completions = nil
+	}
+	switch e.Key() {
+	case tcell.KeyRune:
+		s.prompt.text += string(e.Rune())
+	case tcell.KeyDEL:
+		if s.prompt.text == "" {
+			s.mode = modeNormal
+			return
+		}
+		_, size := utf8.DecodeLastRuneInString(s.prompt.text)
+		s.prompt.text = s.prompt.text[:len(s.prompt.text)-size]
+	case tcell.KeyCR:
+		s.mode = modeNormal
+		s.prompt.submit(s.prompt.text)
+	case tcell.KeyTAB:
+		s.completePrompt()
+	case tcell.KeyESC:
+		s.mode = modeNormal
+	}
+}
+
+// completePrompt completes the prompt text as far as is unambiguous. Once
+// that's done, repeated completion cycles through the candidates.
+func (s *State) completePrompt() {
+	if s.prompt.complete == nil {
+		return
+	}
+	if s.prompt.completions != nil {
+		s.prompt.completion =
+			(s.prompt.completion + 1) % len(s.prompt.completions)
+		s.prompt.text = s.prompt.completions[s.prompt.completion]
+		return
+	}
+	candidates := s.prompt.complete(s.prompt.text)
+	if len(candidates) == 0 {
+		return
+	}
+	if len(candidates) == 1 {
+		s.prompt.text = candidates[0] + " "
+		return
+	}
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if prefix != s.prompt.text {
+		s.prompt.text = prefix
+		return
+	}
+	s.prompt.completions = candidates
+	s.prompt.completion = 0
+	s.prompt.text = candidates[0]
+}
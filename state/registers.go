@@ -0,0 +1,157 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Registers hold the text copied from each selection, in order. They are
+// named by a single character:
+//
+//	"     the default register
+//	a-z   named registers; A-Z append to the lower case register instead
+//	/     the last search pattern, which is read-only
+//	+     the system clipboard
+//
+// The default and named registers are kept in the state directory, so they
+// survive across sessions.
+const (
+	registersFile   = "registers"
+	defaultRegister = '"'
+)
+
+func init() {
+	RegisterCommand("registers", cmdRegisters)
+	registerOption(
+		"register",
+		func(s *State) string { return string(s.defaultRegister()) },
+		func(s *State, value string) error {
+			r := []rune(value)
+			if len(r) != 1 || !validRegister(r[0]) {
+				return fmt.Errorf("invalid register %q", value)
+			}
+			s.DefaultRegister = r[0]
+			return nil
+		},
+	)
+}
+
+func validRegister(r rune) bool {
+	return r == defaultRegister || r == '/' || r == '+' ||
+		r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+func (s *State) defaultRegister() rune {
+	if s.DefaultRegister == 0 {
+		return defaultRegister
+	}
+	return s.DefaultRegister
+}
+
+// selectRegister makes the register named by the next key the one used by
+// the next copy or paste.
+func (s *State) selectRegister() {
+	s.awaitKey(func(k key) {
+		if k.k == tcell.KeyEsc {
+			return
+		}
+		if k.k != tcell.KeyRune || k.mod != 0 || !validRegister(k.r) {
+			s.Msg = fmt.Sprintf("invalid register %v", k)
+			return
+		}
+		s.register = k.r
+	})
+}
+
+// takeRegister returns the register selected for this copy or paste, and
+// resets the selection.
+func (s *State) takeRegister() rune {
+	r := s.register
+	s.register = 0
+	if r == 0 {
+		return s.defaultRegister()
+	}
+	return r
+}
+
+func (s *State) loadRegisters() {
+	if s.registers != nil {
+		return
+	}
+	s.registers = map[string][]string{}
+	if err := readStateFile(registersFile, &s.registers); err != nil {
+		s.Msg = "couldn't load registers: " + err.Error()
+	}
+}
+
+func (s *State) readRegister(r rune) ([]string, error) {
+	switch r {
+	case '/':
+		if s.Search == nil {
+			return nil, errors.New("no search")
+		}
+		return []string{s.Search.String()}, nil
+	case '+':
+		return s.readClipboard()
+	}
+	s.loadRegisters()
+	texts, ok := s.registers[string(unicode.ToLower(r))]
+	if !ok {
+		return nil, fmt.Errorf("register %c is empty", r)
+	}
+	return texts, nil
+}
+
+func (s *State) writeRegister(r rune, texts []string) error {
+	switch r {
+	case '/':
+		return errors.New("register / is read-only")
+	case '+':
+		return s.writeClipboard(texts)
+	}
+	s.loadRegisters()
+	if unicode.IsUpper(r) {
+		r = unicode.ToLower(r)
+		old := s.registers[string(r)]
+		if len(old) == len(texts) {
+			appended := make([]string, len(texts))
+			for i := range texts {
+				appended[i] = old[i] + texts[i]
+			}
+			texts = appended
+		} else {
+			texts = append(append([]string{}, old...), texts...)
+		}
+	}
+	s.registers[string(r)] = texts
+	return writeStateFile(registersFile, s.registers)
+}
+
+// cmdRegisters lists the registers that aren't empty in a menu.
+func cmdRegisters(s *State, args []string) error {
+	s.loadRegisters()
+	var names []string
+	for name := range s.registers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if s.Search != nil {
+		names = append(names, "/")
+	}
+	if len(names) == 0 {
+		return errors.New("no registers")
+	}
+	var items []string
+	for _, name := range names {
+		texts, _ := s.readRegister([]rune(name)[0])
+		preview := strings.ReplaceAll(strings.Join(texts, " | "), "\n", "⏎")
+		items = append(items, name+"  "+preview)
+	}
+	s.startMenu(items, -1, func(int) {})
+	return nil
+}
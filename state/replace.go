@@ -0,0 +1,87 @@
+package state
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+func (s *State) startReplace() {
+	s.startPrompt("replace: ", func(pattern string) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			s.Msg = err.Error()
+			return
+		}
+		s.startPrompt("with: ", func(template string) {
+			n := 0
+			s.startChange()
+			s.forEachSelection(func() { n += s.replace(re, template) })
+			s.endChange()
+			s.Msg = fmt.Sprintf("replaced %v matches", n)
+		})
+	})
+}
+
+// replace replaces every match of re in the selection with template, in
+// which $1 and ${name} are expanded as by regexp.Expand. The replaced text is
+// left selected. Returns the number of matches replaced.
+func (s *State) replace(re *regexp.Regexp, template string) int {
+	from, to := s.normalisedSelection()
+	lines := s.Text.Lines(from.Y, to.Y+1)
+	joined := strings.Join(lines, "\n")
+	start := max(0, from.X)
+	end := len(joined) - len(lines[len(lines)-1])
+	if to.X >= 0 {
+		end += s.xRightOf(&to)
+	}
+	src := []byte(joined[start:end])
+
+	matches := re.FindAllSubmatchIndex(src, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	var dst []byte
+	last := 0
+	for _, m := range matches {
+		dst = append(dst, src[last:m[0]]...)
+		dst = re.Expand(dst, []byte(template), src, m)
+		last = m[1]
+	}
+	dst = append(dst, src[last:]...)
+
+	replaced := joined[:start] + string(dst) + joined[end:]
+	s.applyDiff(diff{
+		start:  from.Y,
+		before: lines,
+		after:  strings.Split(replaced, "\n"),
+	})
+
+	s.Anchor = s.cursorAt(from.Y, replaced, start)
+	s.Cursor = s.Anchor
+	if len(dst) > 0 {
+		_, size := utf8.DecodeLastRune(dst)
+		s.Cursor = s.cursorAt(from.Y, replaced, start+len(dst)-size)
+	}
+	return len(matches)
+}
+
+// cursorAt returns a cursor at byte offset i of text, which is the contents
+// of the buffer from line y onwards joined with newlines. Offsets past the
+// end of a line are clamped to its last character.
+func (s *State) cursorAt(y int, text string, i int) cursor {
+	var c cursor
+	c.Y = y + strings.Count(text[:i], "\n")
+	s.setCursorY(&c, c.Y)
+	x := i - strings.LastIndex(text[:i], "\n") - 1
+	if len(s.Text.Line(c.Y)) == 0 {
+		return c
+	}
+	if x >= len(s.Text.Line(c.Y)) {
+		s.moveEndOfLine(&c)
+	} else {
+		s.setCursorX(&c, x)
+	}
+	return c
+}
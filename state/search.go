@@ -0,0 +1,110 @@
+package state
+
+import "regexp"
+
+func (s *State) startSearch() {
+	s.startPrompt("/", func(pattern string) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			s.Msg = err.Error()
+			return
+		}
+		s.Search = re
+		s.selectMatch(s.searchForward)
+	})
+}
+
+// searchForward finds the first match starting after c, wrapping around the
+// end of the buffer. Matches never span lines.
+func (s *State) searchForward(c cursor) (int, []int) {
+	if s.Search == nil {
+		return 0, nil
+	}
+	for i := 0; i <= s.Text.Len(); i++ {
+		y := (c.Y + i) % s.Text.Len()
+		for _, m := range s.Search.FindAllStringIndex(s.Text.Line(y), -1) {
+			if i == 0 && m[0] <= max(0, c.X) ||
+				i == s.Text.Len() && m[0] > max(0, c.X) {
+				continue
+			}
+			return y, m
+		}
+	}
+	return 0, nil
+}
+
+// searchBackward finds the last match starting before c, wrapping around the
+// start of the buffer.
+func (s *State) searchBackward(c cursor) (int, []int) {
+	if s.Search == nil {
+		return 0, nil
+	}
+	for i := 0; i <= s.Text.Len(); i++ {
+		y := ((c.Y-i)%s.Text.Len() + s.Text.Len()) % s.Text.Len()
+		matches := s.Search.FindAllStringIndex(s.Text.Line(y), -1)
+		for j := len(matches) - 1; j >= 0; j-- {
+			m := matches[j]
+			if i == 0 && m[0] >= max(0, c.X) ||
+				i == s.Text.Len() && m[0] < max(0, c.X) {
+				continue
+			}
+			return y, m
+		}
+	}
+	return 0, nil
+}
+
+// matchCursors returns cursors on the first and last characters of the
+// match m on line y.
+func (s *State) matchCursors(y int, m []int) (cursor, cursor) {
+	var from, to cursor
+	s.setCursorY(&from, y)
+	s.setCursorY(&to, y)
+	if len(s.Text.Line(y)) == 0 {
+		return from, to
+	}
+	s.setCursorX(&from, min(m[0], len(s.Text.Line(y))))
+	s.setCursorX(&to, m[1])
+	if m[1] > m[0] {
+		s.setCursorX(&to, s.xLeftOf(&to))
+	}
+	if from.X == len(s.Text.Line(y)) {
+		s.moveEndOfLine(&from)
+	}
+	if to.X == len(s.Text.Line(y)) {
+		s.moveEndOfLine(&to)
+	}
+	return from, to
+}
+
+// selectMatch selects the match found by search from each cursor.
+func (s *State) selectMatch(search func(cursor) (int, []int)) {
+	if s.Search == nil {
+		s.Msg = "no search pattern"
+		return
+	}
+	found := false
+	s.forEachSelection(func() {
+		if y, m := search(s.Cursor); m != nil {
+			s.Anchor, s.Cursor = s.matchCursors(y, m)
+			found = true
+		}
+	})
+	if !found {
+		s.Msg = "no matches for " + s.Search.String()
+	}
+}
+
+// extendToMatch moves each cursor to the end of the match found by search,
+// leaving anchors where they are.
+func (s *State) extendToMatch(search func(cursor) (int, []int)) {
+	if s.Search == nil {
+		s.Msg = "no search pattern"
+		return
+	}
+	s.extend(func(c *cursor) {
+		if y, m := search(*c); m != nil {
+			_, *c = s.matchCursors(y, m)
+		}
+	})
+}
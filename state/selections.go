@@ -0,0 +1,183 @@
+package state
+
+import "sort"
+
+type Selection struct {
+	Anchor, Cursor cursor
+}
+
+func (sel Selection) normalised() (cursor, cursor) {
+	if before(sel.Cursor, sel.Anchor) {
+		return sel.Cursor, sel.Anchor
+	}
+	return sel.Anchor, sel.Cursor
+}
+
+func before(a, b cursor) bool {
+	return a.Y < b.Y || a.Y == b.Y && max(0, a.X) < max(0, b.X)
+}
+
+// Selections returns every selection, with the primary selection first.
+func (s *State) Selections() []Selection {
+	return append([]Selection{{s.Anchor, s.Cursor}}, s.Secondary...)
+}
+
+func (s *State) setSelections(sels []Selection) {
+	s.Anchor, s.Cursor = sels[0].Anchor, sels[0].Cursor
+	s.Secondary = append([]Selection{}, sels[1:]...)
+}
+
+// forEachSelection calls f once for each selection, with that selection
+// temporarily made primary. Selections are visited from last to first, so
+// edits made by f can only move selections that have already been visited,
+// and applyDiff keeps those in step.
+func (s *State) forEachSelection(f func()) {
+	sels := s.Selections()
+	primary := sels[0]
+	sort.SliceStable(sels, func(i, j int) bool {
+		from, _ := sels[i].normalised()
+		to, _ := sels[j].normalised()
+		return before(from, to)
+	})
+	p := 0
+	for sels[p] != primary {
+		p++
+	}
+	for i := len(sels) - 1; i >= 0; i-- {
+		s.Anchor, s.Cursor = sels[i].Anchor, sels[i].Cursor
+		s.visited = sels[i+1:]
+		f()
+		sels[i] = Selection{s.Anchor, s.Cursor}
+	}
+	s.visited = nil
+	sels[0], sels[p] = sels[p], sels[0]
+	s.setSelections(sels)
+	s.mergeSelections()
+}
+
+// shift moves c to account for d having been applied to text earlier in the
+// buffer than c. A cursor on the last replaced line keeps its distance from
+// the end of that line.
+func (s *State) shift(c *cursor, d diff) {
+	end := d.start + len(d.before)
+	if c.Y >= end {
+		c.Y += len(d.after) - len(d.before)
+		return
+	}
+	if c.Y < d.start {
+		return
+	}
+	if len(d.after) == 0 {
+		c.Y = min(d.start, s.Text.Len()-1)
+		s.setCursorY(c, c.Y)
+		return
+	}
+	suffix := len(d.before[len(d.before)-1]) - max(0, c.X)
+	c.Y = d.start + len(d.after) - 1
+	if c.X == -1 && len(s.Text.Line(c.Y)) == 0 {
+		return
+	}
+	s.setCursorX(c, max(0, len(s.Text.Line(c.Y))-suffix))
+}
+
+// mergeSelections combines overlapping selections into one. A merged
+// selection is primary if any of its parts was.
+func (s *State) mergeSelections() {
+	if len(s.Secondary) == 0 {
+		return
+	}
+	type part struct {
+		Selection
+		primary bool
+	}
+	parts := []part{{Selection{s.Anchor, s.Cursor}, true}}
+	for _, sel := range s.Secondary {
+		parts = append(parts, part{sel, false})
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		from, _ := parts[i].normalised()
+		to, _ := parts[j].normalised()
+		return before(from, to)
+	})
+	merged := parts[:1]
+	for _, p := range parts[1:] {
+		last := &merged[len(merged)-1]
+		lastFrom, lastTo := last.normalised()
+		from, to := p.normalised()
+		if before(lastTo, from) {
+			merged = append(merged, p)
+			continue
+		}
+		if before(lastTo, to) {
+			lastTo = to
+		}
+		last.Anchor, last.Cursor = lastFrom, lastTo
+		last.primary = last.primary || p.primary
+	}
+	s.Secondary = nil
+	for _, p := range merged {
+		if p.primary {
+			s.Anchor, s.Cursor = p.Anchor, p.Cursor
+		} else {
+			s.Secondary = append(s.Secondary, p.Selection)
+		}
+	}
+}
+
+func (s *State) collapseSelections() {
+	s.Anchor = s.Cursor
+	for i := range s.Secondary {
+		s.Secondary[i].Anchor = s.Secondary[i].Cursor
+	}
+}
+
+func (s *State) keepPrimarySelection() {
+	s.Secondary = nil
+}
+
+// addCursorBelow adds a cursor on the line below the last selection, in the
+// same column, and makes it primary.
+func (s *State) addCursorBelow() {
+	last := s.Cursor
+	for _, sel := range s.Secondary {
+		if before(last, sel.Cursor) {
+			last = sel.Cursor
+		}
+	}
+	if last.Y == s.Text.Len()-1 {
+		return
+	}
+	c := last
+	s.moveDown(&c, 1)
+	s.Secondary = append(s.Secondary, Selection{s.Anchor, s.Cursor})
+	s.Anchor, s.Cursor = c, c
+	s.mergeSelections()
+}
+
+// splitLines splits every selection spanning several lines into one
+// selection per line.
+func (s *State) splitLines() {
+	var split []Selection
+	for _, sel := range s.Selections() {
+		from, to := sel.normalised()
+		for y := from.Y; y <= to.Y; y++ {
+			var a, c cursor
+			a.Y, c.Y = y, y
+			if y == from.Y {
+				a = from
+			} else {
+				s.setCursorX(&a, 0)
+			}
+			if y == to.Y {
+				c = to
+			} else {
+				s.moveEndOfLine(&c)
+			}
+			if len(s.Text.Line(y)) == 0 {
+				a.X, c.X = -1, -1
+			}
+			split = append(split, Selection{a, c})
+		}
+	}
+	s.setSelections(split)
+}
@@ -1,7 +1,13 @@
 package state
 
 import (
-	"github.com/gdamore/tcell/v2"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/callum-oakley/vee/lsp"
+	"github.com/callum-oakley/vee/syntax"
+	"github.com/callum-oakley/vee/text"
 )
 
 type mode int
@@ -9,158 +15,118 @@ type mode int
 const (
 	modeNormal mode = iota
 	modeInsert
-	modeSpace
+	modePrompt
+	modeMenu
 )
 
 type cursor struct {
 	X, Y, col int
 }
 
-type State struct {
+// A Buffer is a file open for editing.
+type Buffer struct {
 	FilePath       string
-	TabWidth       int
-	Text           []string
+	Text           text.Buffer
 	Anchor, Cursor cursor
-	mode           mode
-	Msg            string
+	Secondary      []Selection
 	change         change
-	history        []change
-	historyHead    int
+	history        []*undoNode // indexed by seq
+	historyHead    *undoNode
+	saved          *undoNode
+	Highlighter    *syntax.Highlighter
+	Diagnostics    []Diagnostic
+	server         *lsp.Client
+	version        int
+	// disk is the file as it was when last read or written, and noticed is
+	// the hash of the last change to it from outside the editor that's been
+	// reported.
+	disk    fileStamp
+	noticed string
+	// format is how the text is written to its file, and savedFormat is how
+	// it was when last read or written.
+	format, savedFormat format
+	// onChange is called with every diff applied to the text.
+	onChange []func(diff)
+	// Wrap is how lines too long to fit on screen are shown.
+	Wrap Wrap
 }
 
-func (s *State) HandleKey(e *tcell.EventKey) bool {
-	switch s.mode {
-	case modeNormal:
-		switch e.Key() {
-		case tcell.KeyRune:
-			switch e.Rune() {
-			// mode transitions
-			case ' ':
-				s.setMode(modeSpace)
-			case 'a':
-				s.startChange()
-				s.setMode(modeInsert)
-			case 'A':
-				s.startChange()
-				s.newLineAbove()
-				s.setMode(modeInsert)
-			case 'd':
-				s.startChange()
-				s.setCursorX(&s.Cursor, s.xRightOf(&s.Cursor))
-				s.setMode(modeInsert)
-			case 'D':
-				s.startChange()
-				s.move(s.moveEndOfLine)
-				s.setCursorX(&s.Cursor, s.xRightOf(&s.Cursor))
-				s.setMode(modeInsert)
-				s.insert('\n')
-			case 'f':
-				s.startChange()
-				s.delete()
-				s.setMode(modeInsert)
-			case 'F':
-				s.startChange()
-				s.deleteLines()
-				s.newLineAbove()
-				s.setMode(modeInsert)
-
-			// movements
-			case 'y':
-				s.move(s.moveStartOfLine)
-			case 'Y':
-				s.moveStartOfLine(&s.Cursor)
-			case 'o':
-				s.move(s.moveEndOfLine)
-			case 'O':
-				s.moveEndOfLine(&s.Cursor)
-			case 'u':
-				s.move(s.moveStartOfWord)
-			case 'U':
-				s.moveStartOfWord(&s.Cursor)
-			case 'i':
-				s.move(s.moveEndOfWord)
-			case 'I':
-				s.moveEndOfWord(&s.Cursor)
-			case 'h':
-				s.move(s.moveLeft)
-			case 'H':
-				s.moveLeft(&s.Cursor)
-			case 'l':
-				s.move(s.moveRight)
-			case 'L':
-				s.moveRight(&s.Cursor)
-			case 'k':
-				s.move(func(c *cursor) { s.moveUp(c, 1) })
-			case 'K':
-				s.moveUp(&s.Cursor, 1)
-			case 'j':
-				s.move(func(c *cursor) { s.moveDown(c, 1) })
-			case 'J':
-				s.moveDown(&s.Cursor, 1)
+// Modified reports whether the buffer has changed since it was opened or
+// last saved.
+func (b *Buffer) Modified() bool {
+	return b.historyHead != b.saved || b.format != b.savedFormat
+}
 
-			// actions
-			case 'x':
-				s.startChange()
-				s.delete()
-				s.endChange()
-			case 'X':
-				s.startChange()
-				s.deleteLines()
-				s.endChange()
-			case 'z':
-				s.undo()
-			case 'Z':
-				s.redo()
-			case 'w':
-				s.save()
-			case 'c':
-				s.copy()
-			case 'v':
-				s.startChange()
-				s.paste()
-				s.endChange()
-			}
-		case tcell.KeyUp:
-			if e.Modifiers() == tcell.ModShift {
-				s.moveUp(&s.Cursor, 9)
-			} else {
-				s.move(func(c *cursor) { s.moveUp(c, 9) })
-			}
-		case tcell.KeyDown:
-			if e.Modifiers() == tcell.ModShift {
-				s.moveDown(&s.Cursor, 9)
-			} else {
-				s.move(func(c *cursor) { s.moveDown(c, 9) })
-			}
-		case tcell.KeyESC:
-			s.Anchor = s.Cursor
-		}
-	case modeInsert:
-		switch e.Key() {
-		case tcell.KeyRune:
-			s.insert(e.Rune())
-		case tcell.KeyTAB:
-			s.insert('\t')
-		case tcell.KeyCR:
-			s.insert('\n')
-		case tcell.KeyDEL:
-			s.insertBackspace()
-		case 0x17:
-			s.insertBackspaceWord()
-		case tcell.KeyDelete:
-			s.insertDelete()
-		case tcell.KeyESC:
-			s.setMode(modeNormal)
-		}
-	case modeSpace:
-		switch e.Key() {
-		case tcell.KeyRune:
-			switch e.Rune() {
-			case 'q':
-				return true
-			}
-		}
-		s.mode = modeNormal
-	}
-	return false
+// State is the state of the whole editor. The fields of the current buffer
+// are promoted from the embedded Buffer.
+type State struct {
+	*Buffer
+	Buffers  []*Buffer
+	TabWidth int
+	visited  []Selection
+	mode     mode
+	prompt   prompt
+	menu     menu
+	Search   *regexp.Regexp
+	Msg      string
+	keymaps  map[mode]map[string]string
+	// Backup is whether to keep a copy of each file as it was before it's
+	// saved, named with a trailing tilde.
+	Backup bool
+	// ReadOnly is whether saving needs to be forced with write!.
+	ReadOnly bool
+	// LineNumbers and SignColumn set out the gutter beside the text.
+	LineNumbers LineNumbers
+	SignColumn  SignColumn
+	// SideMargin is how many columns to keep between the cursor and the
+	// edges of the screen when scrolling sideways.
+	SideMargin int
+	// ShowBreak marks the rows that lines are word wrapped onto.
+	ShowBreak string
+	// DefaultWrap is the wrap buffers start with when they're opened.
+	DefaultWrap Wrap
+	// KeyTimeout is how long to wait for the rest of a key sequence, or
+	// zero for the default.
+	KeyTimeout time.Duration
+	pending    []key
+	// pendingTimer identifies the latest timeout started for pending keys.
+	pendingTimer int
+	// lastSequence is the key sequence of the binding run most recently.
+	lastSequence []key
+	// keyHandler, if set, takes the next key instead of the keymap.
+	keyHandler func(key)
+	macros     map[string][]key
+	// DefaultRegister is the register used by copy and paste when none is
+	// selected, or zero for the default register.
+	DefaultRegister rune
+	register        rune
+	registers       map[string][]string
+	// clipboard is what was last written to the system clipboard.
+	clipboard []string
+	// clipboardName is the clipboard backend chosen with the clipboard
+	// option, or empty to choose one automatically.
+	clipboardName     string
+	clipboardCommands map[string][]string
+	recording         *recording
+	macroDepth        int
+	// group counts the change groups in progress. While there are any,
+	// changes are combined into one.
+	group int
+	// Interrupt, if set, arranges for a function to be called on the
+	// goroutine handling keys. It's used to deliver results from background
+	// work, such as diagnostics from language servers.
+	Interrupt func(func())
+	// Terminal, if set, is the terminal vee is running in, for writing
+	// escape sequences tcell doesn't know about.
+	Terminal io.Writer
+	// layout arranges the windows, and focus is the leaf of the focused
+	// window, whose buffer is the current buffer.
+	layout   *layout
+	focus    *layout
+	servers  map[string]*lsp.Client
+	watching bool
+	quit     bool
+	// starting holds the buffers waiting for each language's server to
+	// start.
+	starting map[string][]*Buffer
 }
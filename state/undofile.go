@@ -0,0 +1,220 @@
+package state
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// undoVersion is bumped whenever the format of undo files changes. Files with
+// a version we don't know how to read are ignored rather than misread.
+//
+// Version 1 held a linear history. Version 2 holds an undo tree.
+const undoVersion = 2
+
+type undoFile struct {
+	Version int
+	// Hash is the SHA-256 of the file contents at the point the history was
+	// saved. The history only applies to a file with exactly these contents.
+	Hash string
+	// Head is the seq of the current state.
+	Head int
+	// Changes holds every state except the root, in seq order.
+	Changes []undoChange
+}
+
+type undoChange struct {
+	// Parent is the seq of the state the change applies to, and Redo is the
+	// index of the child redo moves to. Version 1 files have neither.
+	Parent, Redo                      int
+	Start                             int
+	Before, After                     []string
+	SelectionsBefore, SelectionsAfter []undoSelection
+}
+
+type undoSelection struct {
+	Anchor, Cursor undoCursor
+}
+
+type undoCursor struct {
+	X, Y, Col int
+}
+
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "vee"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "vee"), nil
+}
+
+// writeStateFile writes v as JSON to the file called name in the state
+// directory.
+func writeStateFile(name string, v interface{}) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	// Key notation is full of angle brackets, which would otherwise be
+	// escaped.
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", data.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readStateFile reads JSON from the file called name in the state directory
+// into v. A missing file leaves v untouched.
+func readStateFile(name string, v interface{}) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// undoName returns the name, in the state directory, of the undo store for
+// the file at filePath.
+func undoName(filePath string) (string, error) {
+	abs, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join("undo", hex.EncodeToString(sum[:])), nil
+}
+
+func contentHash(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// saveHistory writes the history to the undo store for s.FilePath, which has
+// just been written with contents.
+func (s *State) saveHistory(contents []byte) error {
+	name, err := undoName(s.FilePath)
+	if err != nil {
+		return err
+	}
+	u := undoFile{
+		Version: undoVersion,
+		Hash:    contentHash(contents),
+	}
+	if s.historyHead != nil {
+		u.Head = s.historyHead.seq
+	}
+	for _, c := range s.history[min(1, len(s.history)):] {
+		u.Changes = append(u.Changes, undoChange{
+			Parent:           c.parent.seq,
+			Redo:             c.redo,
+			Start:            c.start,
+			Before:           c.before,
+			After:            c.after,
+			SelectionsBefore: toUndoSelections(c.selectionsBefore),
+			SelectionsAfter:  toUndoSelections(c.selectionsAfter),
+		})
+	}
+	return writeStateFile(name, u)
+}
+
+// loadHistory restores the history from the undo store for s.FilePath, if
+// there is one and it was saved when the file had exactly contents.
+func (s *State) loadHistory(contents []byte) error {
+	name, err := undoName(s.FilePath)
+	if err != nil {
+		return err
+	}
+	// A missing store leaves u with no version, so it's ignored below.
+	var u undoFile
+	if err := readStateFile(name, &u); err != nil {
+		return err
+	}
+	if u.Version < 1 || u.Version > undoVersion ||
+		u.Hash != contentHash(contents) ||
+		u.Head < 0 || u.Head > len(u.Changes) {
+		return nil
+	}
+	if u.Version == 1 {
+		for i := range u.Changes {
+			u.Changes[i].Parent = i
+		}
+	}
+	history := []*undoNode{{}}
+	for i, c := range u.Changes {
+		if c.Parent < 0 || c.Parent > i {
+			return fmt.Errorf("malformed undo file %v", name)
+		}
+		node := &undoNode{
+			change: change{
+				diff: diff{
+					start:  c.Start,
+					before: c.Before,
+					after:  c.After,
+				},
+				selectionsBefore: fromUndoSelections(c.SelectionsBefore),
+				selectionsAfter:  fromUndoSelections(c.SelectionsAfter),
+			},
+			seq:    i + 1,
+			parent: history[c.Parent],
+			redo:   c.Redo,
+		}
+		node.parent.children = append(node.parent.children, node)
+		history = append(history, node)
+	}
+	for _, node := range history {
+		if node.redo < 0 || node.redo >= len(node.children) {
+			node.redo = 0
+		}
+	}
+	s.history = history
+	s.historyHead = history[u.Head]
+	return nil
+}
+
+func toUndoSelections(sels []Selection) []undoSelection {
+	var u []undoSelection
+	for _, sel := range sels {
+		u = append(u, undoSelection{
+			Anchor: undoCursor{sel.Anchor.X, sel.Anchor.Y, sel.Anchor.col},
+			Cursor: undoCursor{sel.Cursor.X, sel.Cursor.Y, sel.Cursor.col},
+		})
+	}
+	return u
+}
+
+func fromUndoSelections(u []undoSelection) []Selection {
+	var sels []Selection
+	for _, sel := range u {
+		sels = append(sels, Selection{
+			Anchor: cursor{sel.Anchor.X, sel.Anchor.Y, sel.Anchor.Col},
+			Cursor: cursor{sel.Cursor.X, sel.Cursor.Y, sel.Cursor.Col},
+		})
+	}
+	return sels
+}
@@ -0,0 +1,163 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// undoState returns a state, with its own state directory, and the path of
+// a file in a temporary directory holding contents.
+func undoState(t *testing.T, contents string) (*State, string) {
+	t.Helper()
+	dir := t.TempDir()
+	os.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	t.Cleanup(func() { os.Unsetenv("XDG_STATE_HOME") })
+	path := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return &State{TabWidth: 4}, path
+}
+
+// writeUndoFile writes u as the undo store for the file at path.
+func writeUndoFile(t *testing.T, path string, u interface{}) {
+	t.Helper()
+	name, err := undoName(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateFile(name, u); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// version1 is an undo file as version 1 wrote them, with a linear history
+// and no parents.
+type version1 struct {
+	Version int
+	Hash    string
+	Head    int
+	Changes []struct {
+		Start         int
+		Before, After []string
+	}
+}
+
+func TestLoadVersion1(t *testing.T) {
+	s, path := undoState(t, "b\n")
+	var u version1
+	json.Unmarshal([]byte(`{
+		"Version": 1,
+		"Head": 1,
+		"Changes": [
+			{"Start": 0, "Before": ["a"], "After": ["b"]},
+			{"Start": 0, "Before": ["b"], "After": ["c"]}
+		]
+	}`), &u)
+	u.Hash = contentHash([]byte("b\n"))
+	writeUndoFile(t, path, u)
+	if err := s.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if s.Msg != "" {
+		t.Fatal(s.Msg)
+	}
+	if len(s.history) != 3 || s.historyHead != s.history[1] {
+		t.Fatalf("got %v states, at %v, want 3 at 1",
+			len(s.history), s.historyHead.seq)
+	}
+	// Each change follows the one before it.
+	for i, node := range s.history[1:] {
+		if node.parent != s.history[i] || len(s.history[i].children) != 1 {
+			t.Errorf("change %v isn't the only child of the one before", i+1)
+		}
+	}
+	for _, step := range []struct {
+		name string
+		f    func()
+		line string
+	}{
+		{"undo", s.undo, "a"},
+		{"redo", s.redo, "b"},
+		{"redo", s.redo, "c"},
+	} {
+		step.f()
+		if got := s.Text.Line(0); got != step.line {
+			t.Errorf("%v: got %q, want %q", step.name, got, step.line)
+		}
+	}
+}
+
+func TestLoadIgnored(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		version int
+		hash    string
+	}{
+		{"changed file", undoVersion, contentHash([]byte("a\n"))},
+		{"unknown version", undoVersion + 1, contentHash([]byte("b\n"))},
+		{"no version", 0, contentHash([]byte("b\n"))},
+	} {
+		s, path := undoState(t, "b\n")
+		writeUndoFile(t, path, undoFile{
+			Version: test.version,
+			Hash:    test.hash,
+			Head:    1,
+			Changes: []undoChange{
+				{Start: 0, Before: []string{"a"}, After: []string{"b"}},
+			},
+		})
+		if err := s.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.history) != 1 || s.Msg != "" {
+			t.Errorf("%v: got %v states, %q, want the file ignored",
+				test.name, len(s.history), s.Msg)
+		}
+	}
+}
+
+// TestSaveAndLoad saves a history with branches and loads it again.
+func TestSaveAndLoad(t *testing.T) {
+	s, path := undoState(t, "a\n")
+	if err := s.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	set := func(line string) {
+		edit(s, diff{start: 0, before: []string{s.Text.Line(0)},
+			after: []string{line}})
+	}
+	set("b")
+	set("c")
+	s.undo()
+	set("d")
+	s.undo()
+	s.redo()
+	s.sibling(1)
+	if err := s.save(false); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &State{TabWidth: 4}
+	if err := loaded.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Text.Line(0) != "c" || len(loaded.history) != 4 ||
+		loaded.historyHead.seq != 2 {
+		t.Fatalf("got %q with %v states, at %v",
+			loaded.Text.Line(0), len(loaded.history), loaded.historyHead.seq)
+	}
+	loaded.undo()
+	loaded.redo()
+	if loaded.Text.Line(0) != "c" {
+		t.Errorf("redo went to %q, want the branch last visited",
+			loaded.Text.Line(0))
+	}
+	loaded.sibling(1)
+	if loaded.Text.Line(0) != "d" {
+		t.Errorf("sibling went to %q, want d", loaded.Text.Line(0))
+	}
+}
@@ -0,0 +1,175 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/callum-oakley/vee/charset"
+)
+
+// watchInterval is how often open files are checked for changes made
+// outside the editor.
+const watchInterval = time.Second
+
+// A fileStamp records a version of a file on disk. The modification time and
+// size are cheap to check, and the hash settles whether a file whose time or
+// size has changed really has different contents.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+func init() {
+	RegisterCommand("reload", cmdReload)
+}
+
+func stamp(info os.FileInfo, contents []byte) fileStamp {
+	return fileStamp{info.ModTime(), info.Size(), contentHash(contents)}
+}
+
+// stampFile records the file at path as it is now, having just been read or
+// written with contents.
+func (b *Buffer) stampFile(contents []byte) {
+	if info, err := os.Stat(b.FilePath); err == nil {
+		b.disk = stamp(info, contents)
+	}
+}
+
+// changedOnDisk reports whether b's file has different contents from when
+// it was last read or written, and if so, returns them. A file that has
+// been deleted hasn't changed, since there's nothing to lose by writing it.
+func (b *Buffer) changedOnDisk() ([]byte, bool, error) {
+	info, err := os.Stat(b.FilePath)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if info.ModTime().Equal(b.disk.modTime) && info.Size() == b.disk.size {
+		return nil, false, nil
+	}
+	contents, err := ioutil.ReadFile(b.FilePath)
+	if err != nil {
+		return nil, false, err
+	}
+	if contentHash(contents) == b.disk.hash {
+		b.disk = stamp(info, contents)
+		return nil, false, nil
+	}
+	return contents, true, nil
+}
+
+// reload replaces the text of the current buffer with contents, read from
+// its file and decoded as in decode, as a single change. Selections below
+// the lines that differ move with them, and selections within them stay on
+// the same line where they can.
+func (s *State) reload(contents []byte, e *charset.Encoding) {
+	lines, f := decode(contents, e)
+	start, end := 0, 0
+	for start < s.Text.Len() && start < len(lines) &&
+		s.Text.Line(start) == lines[start] {
+		start++
+	}
+	for end < s.Text.Len()-start && end < len(lines)-start &&
+		s.Text.Line(s.Text.Len()-1-end) == lines[len(lines)-1-end] {
+		end++
+	}
+	d := diff{
+		start:  start,
+		before: s.Text.Lines(start, s.Text.Len()-end),
+		after:  lines[start : len(lines)-end],
+	}
+	s.startChange()
+	sels := s.Selections()
+	s.applyDiff(d)
+	for i := range sels {
+		for _, c := range []*cursor{&sels[i].Anchor, &sels[i].Cursor} {
+			y := c.Y
+			if y >= d.start+len(d.before) {
+				y += len(d.after) - len(d.before)
+			} else if y >= d.start {
+				y = min(y, d.start+max(0, len(d.after)-1))
+			}
+			s.setCursorY(c, min(y, s.Text.Len()-1))
+		}
+	}
+	s.setSelections(sels)
+	s.mergeSelections()
+	s.endChange()
+	s.initHistory()
+	s.saved = s.historyHead
+	s.format, s.savedFormat = f, f
+	s.stampFile(contents)
+}
+
+// cmdReload replaces the text of the current buffer with the contents of its
+// file, decoded in the given encoding if there is one. It can be undone like
+// any other change.
+func cmdReload(s *State, args []string) error {
+	var e *charset.Encoding
+	switch len(args) {
+	case 0:
+	case 1:
+		if e = charset.Find(args[0]); e == nil {
+			return fmt.Errorf("unknown encoding %q", args[0])
+		}
+	default:
+		return errors.New("usage: reload [encoding]")
+	}
+	contents, err := ioutil.ReadFile(s.FilePath)
+	if err != nil {
+		return err
+	}
+	s.reload(contents, e)
+	return nil
+}
+
+// watchFiles starts checking open files for changes in the background, if
+// it hasn't already started and the editor has a way of delivering the
+// results.
+func (s *State) watchFiles() {
+	if s.watching || s.Interrupt == nil {
+		return
+	}
+	s.watching = true
+	var check func()
+	check = func() {
+		s.checkFiles()
+		time.AfterFunc(watchInterval, func() { s.interrupt(check) })
+	}
+	time.AfterFunc(watchInterval, func() { s.interrupt(check) })
+}
+
+// checkFiles looks for open files which have changed on disk. Buffers
+// without changes of their own are reloaded, and otherwise there's a
+// warning, once for each new version of the file.
+func (s *State) checkFiles() {
+	for _, b := range s.Buffers {
+		contents, changed, err := b.changedOnDisk()
+		if err != nil || !changed {
+			continue
+		}
+		hash := contentHash(contents)
+		if hash == b.noticed {
+			continue
+		}
+		b.noticed = hash
+		if b.Modified() || b == s.Buffer && s.mode != modeNormal {
+			s.Msg = fmt.Sprintf(
+				"%v has changed on disk (reload to load it, "+
+					"or write! to overwrite it)",
+				b.FilePath,
+			)
+			continue
+		}
+		current := s.Buffer
+		s.Buffer = b
+		s.reload(contents, nil)
+		s.Buffer = current
+		s.Msg = fmt.Sprintf("reloaded %v, which changed on disk", b.FilePath)
+	}
+}
@@ -0,0 +1,384 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Window shows a buffer, with selections of its own. The focused window's
+// selections live in its buffer, where editing finds them. Those of other
+// windows are kept here, and kept in step with edits to their buffers.
+type Window struct {
+	buffer     *Buffer
+	selections []Selection
+	// Column is the first screen column of text shown when lines aren't
+	// wrapped. The renderer keeps it, so that the view only scrolls sideways
+	// when the cursor gets near an edge.
+	Column int
+}
+
+// A layout arranges windows on screen. It's either a single window, or a
+// split into two or more layouts, side by side if vertical is set and
+// otherwise one above the other.
+type layout struct {
+	window   *Window
+	parent   *layout
+	vertical bool
+	children []*layout
+	// size is the layout's share of its parent, in rows or columns, and x,
+	// y, w and h are where it was last laid out.
+	size       int
+	x, y, w, h int
+}
+
+// A Pane is where a window goes on screen, including its status line on
+// the bottom row.
+type Pane struct {
+	Window     *Window
+	Buffer     *Buffer
+	Selections []Selection
+	Focused    bool
+	X, Y, W, H int
+}
+
+// minPaneHeight fits a line of text and a status line.
+const minPaneHeight = 2
+
+func init() {
+	RegisterCommand("split", func(s *State, args []string) error {
+		return s.split(false, args)
+	})
+	RegisterCommand("vsplit", func(s *State, args []string) error {
+		return s.split(true, args)
+	})
+	RegisterCommand("close", cmdClose)
+	RegisterCommand("only", cmdOnly)
+	RegisterCommand("resize", cmdResize)
+	RegisterCommand("focus", cmdFocus)
+}
+
+// windowLayout returns the root of the layout, starting with a single window
+// onto the current buffer.
+func (s *State) windowLayout() *layout {
+	if s.layout == nil {
+		s.layout = &layout{window: &Window{}}
+		s.focus = s.layout
+	}
+	return s.layout
+}
+
+func (l *layout) leaves() []*layout {
+	if l.window != nil {
+		return []*layout{l}
+	}
+	var leaves []*layout
+	for _, child := range l.children {
+		leaves = append(leaves, child.leaves()...)
+	}
+	return leaves
+}
+
+// Layout lays the windows out on a screen w columns wide and h rows high.
+// Side by side windows are separated by a column, which is left empty.
+// Windows that don't fit on the screen are left out.
+func (s *State) Layout(w, h int) []Pane {
+	root := s.windowLayout()
+	root.layOut(0, 0, w, h)
+	var panes []Pane
+	for _, l := range root.leaves() {
+		if l.w <= 0 || l.h <= 0 {
+			continue
+		}
+		p := Pane{
+			Window:     l.window,
+			Buffer:     l.window.buffer,
+			Selections: l.window.selections,
+			X:          l.x,
+			Y:          l.y,
+			W:          l.w,
+			H:          l.h,
+		}
+		if l == s.focus {
+			p.Buffer, p.Selections, p.Focused = s.Buffer, s.Selections(), true
+		}
+		panes = append(panes, p)
+	}
+	return panes
+}
+
+func (l *layout) layOut(x, y, w, h int) {
+	l.x, l.y, l.w, l.h = x, y, w, h
+	if l.window != nil {
+		return
+	}
+	total, gap := h, 0
+	if l.vertical {
+		total, gap = w, 1
+	}
+	avail := total - gap*(len(l.children)-1)
+	sum := 0
+	for _, child := range l.children {
+		sum += max(1, child.size)
+	}
+	// Share out the space in proportion to the sizes the children had, so
+	// that they keep their proportions when the screen is resized. Every
+	// child gets at least one row or column while there's room, and the
+	// children that don't fit are left with none, and hidden.
+	offset := 0
+	for i, child := range l.children {
+		size := avail * max(1, child.size) / sum
+		if i == len(l.children)-1 {
+			size = total - offset
+		}
+		size = max(0, min(max(1, size), total-offset))
+		if size > 0 {
+			child.size = size
+		}
+		if l.vertical {
+			child.layOut(x+offset, y, size, h)
+		} else {
+			child.layOut(x, y+offset, w, size)
+		}
+		offset += size + gap
+	}
+}
+
+// focusOn makes the window at leaf l the focused window.
+func (s *State) focusOn(l *layout) {
+	s.windowLayout()
+	if l == s.focus {
+		return
+	}
+	if s.focus != nil {
+		s.focus.window.buffer = s.Buffer
+		s.focus.window.selections = s.Selections()
+	}
+	s.focus = l
+	s.Buffer = l.window.buffer
+	s.setSelections(l.window.selections)
+}
+
+// shiftWindows keeps the selections of the windows onto b other than the
+// focused one in step with d, which has just been applied to b.
+func (s *State) shiftWindows(b *Buffer, d diff) {
+	if s.layout == nil {
+		return
+	}
+	current := s.Buffer
+	s.Buffer = b
+	for _, l := range s.layout.leaves() {
+		if l == s.focus || l.window.buffer != b {
+			continue
+		}
+		for i := range l.window.selections {
+			s.shift(&l.window.selections[i].Anchor, d)
+			s.shift(&l.window.selections[i].Cursor, d)
+		}
+	}
+	s.Buffer = current
+}
+
+// split splits the focused window in two, both onto the current buffer,
+// and focuses the new one, which is below or to the right. If a size is
+// given, the new window gets that many rows or columns, and otherwise half.
+func (s *State) split(vertical bool, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: split [size]")
+	}
+	s.windowLayout()
+	old := s.focus
+	total := old.h
+	if vertical {
+		total = old.w - 1
+	}
+	size := total / 2
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid size %q", args[0])
+		}
+		size = n
+	}
+	least := 1
+	if !vertical {
+		least = minPaneHeight
+	}
+	if old.h > 0 && (size < least || total-size < least) {
+		return errors.New("not enough room to split")
+	}
+	parent := old.parent
+	if parent == nil || parent.vertical != vertical {
+		// Put a split in the old window's place, with the old window as its
+		// only child so far.
+		split := &layout{
+			vertical: vertical,
+			size:     old.size,
+			x:        old.x,
+			y:        old.y,
+			w:        old.w,
+			h:        old.h,
+		}
+		s.replaceLayout(old, split)
+		split.children = []*layout{old}
+		old.parent = split
+		old.size = total
+		parent = split
+	}
+	old.size = max(1, old.size-size)
+	new := &layout{
+		window: &Window{buffer: s.Buffer, selections: s.Selections()},
+		parent: parent,
+		size:   size,
+	}
+	i := parent.index(old) + 1
+	parent.children = append(
+		parent.children[:i],
+		append([]*layout{new}, parent.children[i:]...)...,
+	)
+	s.focusOn(new)
+	return nil
+}
+
+// replaceLayout puts new in old's place in the layout.
+func (s *State) replaceLayout(old, new *layout) {
+	new.parent = old.parent
+	if old.parent == nil {
+		s.layout = new
+		return
+	}
+	old.parent.children[old.parent.index(old)] = new
+}
+
+func (l *layout) index(child *layout) int {
+	for i, c := range l.children {
+		if c == child {
+			return i
+		}
+	}
+	return -1
+}
+
+// cmdClose closes the focused window, giving its space to its neighbour.
+func cmdClose(s *State, args []string) error {
+	s.windowLayout()
+	l := s.focus
+	if l.parent == nil {
+		return errors.New("can't close the last window")
+	}
+	parent := l.parent
+	i := parent.index(l)
+	parent.children = append(parent.children[:i], parent.children[i+1:]...)
+	neighbour := parent.children[max(0, i-1)]
+	neighbour.size += l.size
+	if len(parent.children) == 1 {
+		neighbour.size = parent.size
+		s.replaceLayout(parent, neighbour)
+	}
+	// The closed window is no longer focused, so there's nothing of it to
+	// keep.
+	s.focus = nil
+	s.focusOn(neighbour.leaves()[0])
+	return nil
+}
+
+// cmdOnly closes every window but the focused one.
+func cmdOnly(s *State, args []string) error {
+	s.windowLayout()
+	s.focus.parent = nil
+	s.layout = s.focus
+	return nil
+}
+
+// cmdResize changes the size of the focused window within the split it's
+// part of, to a number of rows or columns, or by that many with a sign. The
+// window's neighbour gives or takes the difference.
+func cmdResize(s *State, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: resize [+|-]size")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid size %q", args[0])
+	}
+	s.windowLayout()
+	l := s.focus
+	if l.parent == nil {
+		return errors.New("no split to resize within")
+	}
+	parent := l.parent
+	i := parent.index(l)
+	var neighbour *layout
+	if i+1 < len(parent.children) {
+		neighbour = parent.children[i+1]
+	} else {
+		neighbour = parent.children[i-1]
+	}
+	if !strings.HasPrefix(args[0], "+") && !strings.HasPrefix(args[0], "-") {
+		n -= l.size
+	}
+	least := 1
+	if !parent.vertical {
+		least = minPaneHeight
+	}
+	n = max(least-l.size, min(n, neighbour.size-least))
+	l.size += n
+	neighbour.size -= n
+	return nil
+}
+
+// cmdFocus moves the focus to the next or previous window, or to the
+// nearest window in a direction.
+func cmdFocus(s *State, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: focus next|previous|left|right|up|down")
+	}
+	leaves := s.windowLayout().leaves()
+	i := 0
+	for j, l := range leaves {
+		if l == s.focus {
+			i = j
+		}
+	}
+	f := s.focus
+	switch args[0] {
+	case "next":
+		s.focusOn(leaves[(i+1)%len(leaves)])
+		return nil
+	case "previous":
+		s.focusOn(leaves[(i+len(leaves)-1)%len(leaves)])
+		return nil
+	}
+	var best *layout
+	bestDistance := 0
+	for _, l := range leaves {
+		var distance int
+		var overlaps bool
+		switch args[0] {
+		case "left":
+			distance = f.x - (l.x + l.w)
+			overlaps = l.y < f.y+f.h && f.y < l.y+l.h
+		case "right":
+			distance = l.x - (f.x + f.w)
+			overlaps = l.y < f.y+f.h && f.y < l.y+l.h
+		case "up":
+			distance = f.y - (l.y + l.h)
+			overlaps = l.x < f.x+f.w && f.x < l.x+l.w
+		case "down":
+			distance = l.y - (f.y + f.h)
+			overlaps = l.x < f.x+f.w && f.x < l.x+l.w
+		default:
+			return fmt.Errorf("unknown direction %q", args[0])
+		}
+		if l != f && overlaps && distance >= 0 &&
+			(best == nil || distance < bestDistance) {
+			best, bestDistance = l, distance
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("no window %v", args[0])
+	}
+	s.focusOn(best)
+	return nil
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"os"
+	"testing"
+)
+
+// checkPanes checks that panes lie within a screen w columns wide and h
+// rows high, without overlapping.
+func checkPanes(t *testing.T, panes []Pane, w, h int) {
+	t.Helper()
+	used := map[[2]int]bool{}
+	for _, p := range panes {
+		if p.W < 1 || p.H < 1 || p.X < 0 || p.Y < 0 ||
+			p.X+p.W > w || p.Y+p.H > h {
+			t.Errorf("pane %+v is off a %vx%v screen", p, w, h)
+			continue
+		}
+		for x := p.X; x < p.X+p.W; x++ {
+			for y := p.Y; y < p.Y+p.H; y++ {
+				if used[[2]int{x, y}] {
+					t.Errorf("pane %+v overlaps another at %v,%v", p, x, y)
+				}
+				used[[2]int{x, y}] = true
+			}
+		}
+	}
+}
+
+func TestLayoutSmallScreen(t *testing.T) {
+	os.Setenv("XDG_STATE_HOME", t.TempDir())
+	defer os.Unsetenv("XDG_STATE_HOME")
+	for _, test := range []struct {
+		split string
+		w, h  int
+	}{
+		{"split", 80, 5},
+		{"split", 80, 1},
+		{"vsplit", 7, 24},
+		{"vsplit", 1, 24},
+	} {
+		s := &State{TabWidth: 4}
+		if err := s.Open(""); err != nil {
+			t.Fatal(err)
+		}
+		s.Layout(200, 200)
+		for i := 0; i < 6; i++ {
+			if err := s.runCommand(test.split); err != nil {
+				t.Fatal(err)
+			}
+			s.Layout(200, 200)
+		}
+		if n := len(s.Layout(200, 200)); n != 7 {
+			t.Fatalf("%v: %v windows, want 7", test.split, n)
+		}
+		panes := s.Layout(test.w, test.h)
+		checkPanes(t, panes, test.w, test.h)
+		if len(panes) == 0 {
+			t.Errorf("%v on %vx%v: no windows", test.split, test.w, test.h)
+		}
+		// Everything comes back when there's room again.
+		panes = s.Layout(200, 200)
+		checkPanes(t, panes, 200, 200)
+		if len(panes) != 7 {
+			t.Errorf("%v: %v windows after growing", test.split, len(panes))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Wrap is how a buffer shows lines too long to fit on screen. They're
+// either wrapped onto as many rows as they need, or cut off, with the view
+// scrolling sideways to follow the cursor. Lines can be wrapped at any
+// character, or at whitespace, with the rows they wrap onto indented to
+// match them and marked with the showbreak option.
+type Wrap int
+
+const (
+	CharWrap Wrap = iota
+	NoWrap
+	WordWrap
+)
+
+var wrapNames = map[Wrap]string{
+	CharWrap: "on",
+	NoWrap:   "off",
+	WordWrap: "word",
+}
+
+func init() {
+	// Wrap belongs to each buffer. Set with no buffer open, as in the config
+	// file, it's the default for the buffers opened after.
+	registerOption(
+		"wrap",
+		func(s *State) string {
+			if s.Buffer == nil {
+				return wrapNames[s.DefaultWrap]
+			}
+			return wrapNames[s.Wrap]
+		},
+		func(s *State, value string) error {
+			for w, name := range wrapNames {
+				if value != name {
+					continue
+				}
+				if s.Buffer == nil {
+					s.DefaultWrap = w
+				} else {
+					s.Wrap = w
+				}
+				return nil
+			}
+			return fmt.Errorf("invalid wrap %q", value)
+		},
+	)
+	registerOption(
+		"sidemargin",
+		func(s *State) string { return strconv.Itoa(s.SideMargin) },
+		func(s *State, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid side margin %q", value)
+			}
+			s.SideMargin = n
+			return nil
+		},
+	)
+	registerOption(
+		"showbreak",
+		func(s *State) string { return s.ShowBreak },
+		func(s *State, value string) error {
+			s.ShowBreak = value
+			return nil
+		},
+	)
+}
@@ -0,0 +1,178 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// maxSymlinks limits how many symlinks writeFile follows, so that a loop of
+// them is an error rather than a hang.
+const maxSymlinks = 40
+
+func init() {
+	registerOption(
+		"backup",
+		func(s *State) string { return strconv.FormatBool(s.Backup) },
+		func(s *State, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", value)
+			}
+			s.Backup = b
+			return nil
+		},
+	)
+	registerOption(
+		"readonly",
+		func(s *State) string { return strconv.FormatBool(s.ReadOnly) },
+		func(s *State, value string) error {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", value)
+			}
+			s.ReadOnly = b
+			return nil
+		},
+	)
+}
+
+// writeFile replaces the contents of the file at path, without leaving it
+// truncated if something goes wrong part way through. The new contents are
+// written to a temporary file alongside the old one, synced, and renamed
+// over it, keeping its permissions and owner. Symlinks are followed so that
+// it's their target that's replaced.
+//
+// If the file has other hard links, or its owner can't be kept, renaming
+// would split it from the rest of its links or change its owner, so it's
+// overwritten in place instead. If backup is set, the old contents are first
+// copied to a file of the same name followed by a tilde.
+func writeFile(path string, contents []byte, backup bool) error {
+	path, err := resolveSymlinks(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return writeNewFile(path, contents)
+	} else if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%v isn't a regular file", path)
+	}
+	if backup {
+		if err := copyFile(path, path+"~", info.Mode().Perm()); err != nil {
+			return fmt.Errorf("couldn't back up %v: %v", path, err)
+		}
+	}
+	if hardLinked(info) {
+		return writeInPlace(path, contents)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeAndSync(tmp, contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	mode := info.Mode() &
+		(os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := chown(tmp, info); err != nil {
+		tmp.Close()
+		return writeInPlace(path, contents)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// resolveSymlinks follows symlinks from path until it reaches something that
+// isn't one, which may not exist yet.
+func resolveSymlinks(path string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) || err == nil && info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", fmt.Errorf("too many levels of symlinks in %v", path)
+}
+
+// writeNewFile writes a file that doesn't exist yet. There's nothing to lose
+// if it's cut short, so it's written directly.
+func writeNewFile(path string, contents []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	if err := writeAndSync(f, contents); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+func writeInPlace(path string, contents []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if err := writeAndSync(f, contents); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeAndSync(f *os.File, contents []byte) error {
+	if _, err := f.Write(contents); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func copyFile(from, to string, perm os.FileMode) error {
+	contents, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(to, contents, perm)
+}
+
+// syncDir makes a rename or file creation in dir durable. Not every platform
+// supports syncing a directory, and the write itself has already succeeded,
+// so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
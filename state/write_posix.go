@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package state
+
+import (
+	"os"
+	"syscall"
+)
+
+func hardLinked(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Nlink > 1
+}
+
+// chown gives f the owner and group of the file described by info, if
+// they're not already its own.
+func chown(f *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Uid) == os.Geteuid() && int(st.Gid) == os.Getegid() {
+		return nil
+	}
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
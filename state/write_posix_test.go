@@ -0,0 +1,83 @@
+//go:build !windows
+// +build !windows
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileThroughSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := ioutil.WriteFile(target, []byte("one\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// A relative link to a link, to check both are followed.
+	if err := os.Symlink("target", filepath.Join(dir, "link1")); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link2")
+	if err := os.Symlink(filepath.Join(dir, "link1"), link); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(link, []byte("two\n"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, target); got != "two\n" {
+		t.Errorf("target has %q, want %q", got, "two\n")
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("the link was replaced by a file")
+	}
+}
+
+func TestWriteFileSymlinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.Symlink(b, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(a, []byte("x"), false); err == nil {
+		t.Error("writing through a loop of symlinks should fail")
+	}
+}
+
+func TestWriteFileHardLinked(t *testing.T) {
+	dir := t.TempDir()
+	path, other := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := ioutil.WriteFile(path, []byte("one\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(path, other); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(path, []byte("two\n"), false); err != nil {
+		t.Fatal(err)
+	}
+	// Both links still name the same file, which has the new contents.
+	if got := readFile(t, other); got != "two\n" {
+		t.Errorf("other link has %q, want %q", got, "two\n")
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("the file was replaced rather than written in place")
+	}
+}
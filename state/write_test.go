@@ -0,0 +1,62 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	if err := writeFile(path, []byte("one\n"), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "one\n" {
+		t.Errorf("new file has %q", got)
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(path, []byte("two\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "two\n" {
+		t.Errorf("file has %q, want %q", got, "two\n")
+	}
+	if got := readFile(t, path+"~"); got != "one\n" {
+		t.Errorf("backup has %q, want %q", got, "one\n")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("file has mode %v, want %v", info.Mode().Perm(), 0640)
+	}
+	// Nothing is left behind by the temporary file.
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%v files in the directory, want the file and its backup",
+			len(entries))
+	}
+}
+
+func TestWriteFileNotRegular(t *testing.T) {
+	if err := writeFile(t.TempDir(), []byte("x"), false); err == nil {
+		t.Error("writing over a directory should fail")
+	}
+}
@@ -0,0 +1,11 @@
+package state
+
+import "os"
+
+func hardLinked(info os.FileInfo) bool {
+	return false
+}
+
+func chown(f *os.File, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package syntax
+
+func init() {
+	for _, lang := range []*Language{
+		golang, markdown, json, yaml, shell,
+	} {
+		if err := Register(lang); err != nil {
+			panic(err)
+		}
+	}
+}
+
+var golang = &Language{
+	Name:       "go",
+	Extensions: []string{".go"},
+	States: map[string][]Rule{
+		"root": {
+			{Pattern: `//.*`, Token: Comment},
+			{Pattern: `/\*`, Token: Comment, Next: "comment"},
+			{Pattern: "`", Token: String, Next: "raw"},
+			{Pattern: `"(\\.|[^"\\])*"`, Token: String},
+			{Pattern: `'(\\.|[^'\\])*'`, Token: String},
+			{
+				Pattern: `\b(break|case|chan|const|continue|default|defer|` +
+					`else|fallthrough|for|func|go|goto|if|import|` +
+					`interface|map|package|range|return|select|struct|` +
+					`switch|type|var)\b`,
+				Token: Keyword,
+			},
+			{
+				Pattern: `\b(any|bool|byte|comparable|complex64|complex128|` +
+					`error|float32|float64|int|int8|int16|int32|int64|rune|` +
+					`string|uint|uint8|uint16|uint32|uint64|uintptr)\b`,
+				Token: Type,
+			},
+			{Pattern: `\b(true|false|nil|iota)\b`, Token: Constant},
+			{Pattern: `\b[0-9][0-9a-zA-Z_]*(\.[0-9a-zA-Z_]*)?`, Token: Number},
+			{Pattern: `\.[0-9][0-9a-zA-Z_]*`, Token: Number},
+			{Pattern: `\w+`},
+		},
+		"comment": {
+			{Pattern: `.*?\*/`, Token: Comment, Next: "root"},
+			{Pattern: `[^*]+`, Token: Comment},
+		},
+		"raw": {
+			{Pattern: "[^`]*`", Token: String, Next: "root"},
+			{Pattern: "[^`]+", Token: String},
+		},
+	},
+	Defaults: map[string]Token{"comment": Comment, "raw": String},
+}
+
+var markdown = &Language{
+	Name:       "markdown",
+	Extensions: []string{".md", ".markdown"},
+	States: map[string][]Rule{
+		"root": {
+			{Pattern: "^```", Token: Code, Next: "fence"},
+			{Pattern: `^#{1,6}(\s.*)?$`, Token: Heading},
+			{Pattern: `^>.*`, Token: Comment},
+			{Pattern: `^\s*([-*+]|[0-9]+\.)\s`, Token: Keyword},
+			{Pattern: "`[^`]+`", Token: Code},
+			{Pattern: `\*\*[^*]+\*\*|__[^_]+__`, Token: Emphasis},
+			{Pattern: `\*[^*\s][^*]*\*|\b_[^_\s][^_]*_\b`, Token: Emphasis},
+			{Pattern: `!?\[[^\]]*\]\([^)]*\)`, Token: Link},
+			{Pattern: `<https?://[^>]+>`, Token: Link},
+			{Pattern: `\w+`},
+		},
+		"fence": {
+			{Pattern: "^```.*", Token: Code, Next: "root"},
+			{Pattern: ".+", Token: Code},
+		},
+	},
+	Defaults: map[string]Token{"fence": Code},
+}
+
+var json = &Language{
+	Name:       "json",
+	Extensions: []string{".json"},
+	States: map[string][]Rule{
+		"root": {
+			{Pattern: `"(\\.|[^"\\])*"\s*:`, Token: Key},
+			{Pattern: `"(\\.|[^"\\])*"`, Token: String},
+			{Pattern: `-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]+)?`, Token: Number},
+			{Pattern: `\b(true|false|null)\b`, Token: Constant},
+		},
+	},
+}
+
+var yaml = &Language{
+	Name:       "yaml",
+	Extensions: []string{".yml", ".yaml"},
+	States: map[string][]Rule{
+		"root": {
+			{Pattern: `^#.*`, Token: Comment},
+			{Pattern: `\s+#.*`, Token: Comment},
+			{Pattern: `^(---|\.\.\.)(\s.*)?$`, Token: Keyword},
+			{Pattern: `^\s*-(\s|$)`, Token: Keyword},
+			{Pattern: `[\w.-]+\s*:(\s|$)`, Token: Key},
+			{Pattern: `"(\\.|[^"\\])*"|'([^']|'')*'`, Token: String},
+			{Pattern: `\b(true|false|yes|no|on|off|null)\b|~`, Token: Constant},
+			{Pattern: `-?\b[0-9]+(\.[0-9]+)?\b`, Token: Number},
+			{Pattern: `[&*][\w-]+`, Token: Variable},
+			{Pattern: `!!?[\w/]*`, Token: Type},
+			{Pattern: `[|>][-+]?\s*$`, Token: Keyword},
+			{Pattern: `\w+`},
+		},
+	},
+}
+
+var shell = &Language{
+	Name:       "shell",
+	Extensions: []string{".sh", ".bash", ".zsh"},
+	Shebang:    `^#!.*\b(sh|bash|zsh|ksh|dash)\b`,
+	States: map[string][]Rule{
+		"root": {
+			{Pattern: `^#.*`, Token: Comment},
+			{Pattern: `\s+#.*`, Token: Comment},
+			{Pattern: `'[^']*'`, Token: String},
+			{Pattern: `'`, Token: String, Next: "single"},
+			{Pattern: `"`, Token: String, Next: "double"},
+			{Pattern: `\$\{[^}]*\}|\$\w+|\$[@*#?$!0-9-]`, Token: Variable},
+			{
+				Pattern: `\b(if|then|else|elif|fi|for|while|until|do|done|` +
+					`case|esac|in|function|select|return|local|export|` +
+					`readonly|declare|unset|shift|break|continue|exit|` +
+					`source)\b`,
+				Token: Keyword,
+			},
+			{Pattern: `\b\w+=`, Token: Variable},
+			{Pattern: `\b[0-9]+\b`, Token: Number},
+			{Pattern: `\w+`},
+		},
+		"single": {
+			{Pattern: `[^']*'`, Token: String, Next: "root"},
+			{Pattern: `[^']+`, Token: String},
+		},
+		"double": {
+			{Pattern: `\\.`, Token: String},
+			{Pattern: `\$\{[^}]*\}|\$\w+|\$[@*#?$!0-9-]`, Token: Variable},
+			{Pattern: `"`, Token: String, Next: "root"},
+			{Pattern: `[^"\\$]+`, Token: String},
+		},
+	},
+	Defaults: map[string]Token{"single": String, "double": String},
+}
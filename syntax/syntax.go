@@ -0,0 +1,266 @@
+// Package syntax highlights text using languages defined as tables of
+// regular expression rules.
+package syntax
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/callum-oakley/vee/text"
+)
+
+// A Token classifies a span of text for highlighting.
+type Token string
+
+const (
+	Plain    Token = ""
+	Comment  Token = "comment"
+	Keyword  Token = "keyword"
+	Type     Token = "type"
+	String   Token = "string"
+	Number   Token = "number"
+	Constant Token = "constant"
+	Variable Token = "variable"
+	Key      Token = "key"
+	Heading  Token = "heading"
+	Emphasis Token = "emphasis"
+	Code     Token = "code"
+	Link     Token = "link"
+)
+
+// Tokens lists every token other than Plain.
+var Tokens = []Token{
+	Comment, Keyword, Type, String, Number, Constant, Variable, Key, Heading,
+	Emphasis, Code, Link,
+}
+
+// A Rule matches Pattern at the current position in a line, highlights the
+// match as Token, and if Next is set, moves the lexer to the state Next. A
+// Pattern starting with ^ only matches at the start of a line.
+type Rule struct {
+	Pattern string
+	Token   Token
+	Next    string
+	re      *regexp.Regexp
+	bol     bool
+}
+
+// A Language is a set of lexer states, each of which is a list of rules
+// tried in order. Lexing starts in the state "root". Text that matches no
+// rule is highlighted as the state's token in Defaults, or Plain if it has
+// none.
+type Language struct {
+	Name string
+	// Extensions lists the file extensions of the language, including the
+	// leading dot.
+	Extensions []string
+	// Shebang matches the first line of scripts in the language.
+	Shebang  string
+	States   map[string][]Rule
+	Defaults map[string]Token
+	shebang  *regexp.Regexp
+}
+
+var languages []*Language
+
+// Register compiles lang and makes it available to Detect and Find.
+func Register(lang *Language) error {
+	if _, ok := lang.States["root"]; !ok {
+		return fmt.Errorf("language %v has no root state", lang.Name)
+	}
+	for state, rules := range lang.States {
+		for i := range rules {
+			r := &rules[i]
+			if _, ok := lang.States[r.Next]; r.Next != "" && !ok {
+				return fmt.Errorf(
+					"language %v: unknown state %q", lang.Name, r.Next,
+				)
+			}
+			r.bol = strings.HasPrefix(r.Pattern, "^")
+			re, err := regexp.Compile(
+				`^(?:` + strings.TrimPrefix(r.Pattern, "^") + `)`,
+			)
+			if err != nil {
+				return fmt.Errorf(
+					"language %v, state %v: %v", lang.Name, state, err,
+				)
+			}
+			r.re = re
+		}
+	}
+	if lang.Shebang != "" {
+		re, err := regexp.Compile(lang.Shebang)
+		if err != nil {
+			return fmt.Errorf("language %v: %v", lang.Name, err)
+		}
+		lang.shebang = re
+	}
+	languages = append(languages, lang)
+	return nil
+}
+
+// Find returns the language called name, or nil if there isn't one.
+func Find(name string) *Language {
+	for _, lang := range languages {
+		if lang.Name == name {
+			return lang
+		}
+	}
+	return nil
+}
+
+// Detect returns the language of the file at path whose first line is
+// firstLine, or nil if it isn't recognised.
+func Detect(path, firstLine string) *Language {
+	ext := filepath.Ext(path)
+	for _, lang := range languages {
+		for _, e := range lang.Extensions {
+			if e == ext {
+				return lang
+			}
+		}
+	}
+	if strings.HasPrefix(firstLine, "#!") {
+		for _, lang := range languages {
+			if lang.shebang != nil && lang.shebang.MatchString(firstLine) {
+				return lang
+			}
+		}
+	}
+	return nil
+}
+
+// A Span highlights the bytes [Start, End) of a line as Token.
+type Span struct {
+	Start, End int
+	Token      Token
+}
+
+// lex highlights line, starting in state, and returns the spans found along
+// with the state at the end of the line.
+func (lang *Language) lex(line, state string) ([]Span, string) {
+	var spans []Span
+	add := func(start, end int, token Token) {
+		if n := len(spans); n > 0 && spans[n-1].Token == token &&
+			spans[n-1].End == start {
+			spans[n-1].End = end
+		} else if token != Plain {
+			spans = append(spans, Span{start, end, token})
+		}
+	}
+	pos := 0
+	for pos < len(line) {
+		matched := false
+		for _, r := range lang.States[state] {
+			if r.bol && pos != 0 {
+				continue
+			}
+			m := r.re.FindStringIndex(line[pos:])
+			if m == nil || m[1] == 0 && (r.Next == "" || r.Next == state) {
+				continue
+			}
+			add(pos, pos+m[1], r.Token)
+			pos += m[1]
+			if r.Next != "" {
+				state = r.Next
+			}
+			matched = true
+			break
+		}
+		if !matched {
+			_, size := utf8.DecodeRuneInString(line[pos:])
+			add(pos, pos+size, lang.Defaults[state])
+			pos += size
+		}
+	}
+	return spans, state
+}
+
+// A Highlighter highlights a buffer in a language. It caches the lexer state
+// at the start of each line, so after an edit only the edited lines, and any
+// following lines whose state the edit changed, are lexed again.
+type Highlighter struct {
+	Language *Language
+	// states[y] is the lexer state at the start of line y. It is known to
+	// be correct for y <= valid. Beyond that, it is a guess left over from
+	// before the last edit, which is correct from the first line at or past
+	// dirty where it agrees with a freshly lexed state.
+	states       []string
+	valid, dirty int
+}
+
+func NewHighlighter(lang *Language) *Highlighter {
+	return &Highlighter{Language: lang, states: []string{"root"}}
+}
+
+// Edit tells h that removed lines starting at line start have been replaced
+// by added lines.
+func (h *Highlighter) Edit(start, removed, added int) {
+	if start+1 >= len(h.states) {
+		h.states = h.states[:min(len(h.states), start+1)]
+	} else {
+		// The states of the added lines after the first are unknown, and
+		// the rest move along to make room for them. When the number of
+		// lines hasn't changed, nothing needs to move.
+		from := start + removed
+		if added == 0 {
+			from++
+		}
+		from = min(from, len(h.states))
+		end := start + 1 + max(0, added-1)
+		if end != from {
+			n := end + len(h.states) - from
+			if n > len(h.states) {
+				h.states = append(h.states, make([]string, n-len(h.states))...)
+			}
+			copy(h.states[end:], h.states[from:])
+			h.states = h.states[:n]
+		}
+		for i := start + 1; i < end; i++ {
+			h.states[i] = ""
+		}
+	}
+	if h.dirty > start {
+		h.dirty = max(start+added, h.dirty+added-removed)
+	} else {
+		h.dirty = start + added
+	}
+	h.valid = min(h.valid, start)
+}
+
+// Spans returns the highlighted spans of line y of t.
+func (h *Highlighter) Spans(t text.Buffer, y int) []Span {
+	for h.valid < y {
+		_, state := h.Language.lex(t.Line(h.valid), h.states[h.valid])
+		h.valid++
+		if h.valid >= len(h.states) {
+			h.states = append(h.states, state)
+		} else if h.valid >= h.dirty && h.states[h.valid] == state {
+			h.valid = len(h.states) - 1
+		} else {
+			// The cached states that follow were lexed from the old state,
+			// so they can't be trusted before the next line.
+			h.states[h.valid] = state
+			h.dirty = max(h.dirty, h.valid+1)
+		}
+	}
+	spans, _ := h.Language.lex(t.Line(y), h.states[y])
+	return spans
+}
+
+func min(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max(x, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,68 @@
+package syntax
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/callum-oakley/vee/text"
+)
+
+// checkSpans compares the spans h gives for lines [start, end) of t with
+// those of a fresh highlighter.
+func checkSpans(t *testing.T, h *Highlighter, buf text.Buffer, start, end int) {
+	t.Helper()
+	fresh := NewHighlighter(h.Language)
+	for y := start; y < min(end, buf.Len()); y++ {
+		got, want := h.Spans(buf, y), fresh.Spans(buf, y)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("line %v %q: got %v, want %v", y, buf.Line(y), got, want)
+		}
+	}
+}
+
+func replace(buf text.Buffer, h *Highlighter, start, end int, lines []string) {
+	buf.Replace(start, end, lines)
+	h.Edit(start, end-start, len(lines))
+}
+
+func TestSpansAfterEdits(t *testing.T) {
+	lines := []string{"/*"}
+	for i := 0; i < 30; i++ {
+		lines = append(lines, "x := 1")
+	}
+	lines = append(lines, "*/", "y := 2")
+	buf := text.New(lines)
+	h := NewHighlighter(Find("go"))
+	checkSpans(t, h, buf, 0, 20)
+	replace(buf, h, 0, 1, []string{"x := 0"})
+	checkSpans(t, h, buf, 0, 10)
+	replace(buf, h, 8, 9, []string{"x := 2", "x := 3"})
+	checkSpans(t, h, buf, 0, buf.Len())
+}
+
+func TestSpansAfterRandomEdits(t *testing.T) {
+	choices := []string{"x := 1", "/*", "*/", `s := "a`, "`", "", "// c"}
+	r := rand.New(rand.NewSource(1))
+	line := func() string { return choices[r.Intn(len(choices))] }
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, line())
+	}
+	buf := text.New(lines)
+	h := NewHighlighter(Find("go"))
+	for i := 0; i < 2000; i++ {
+		start := r.Intn(buf.Len() + 1)
+		end := min(buf.Len(), start+r.Intn(3))
+		var added []string
+		for j := r.Intn(3); j > 0; j-- {
+			added = append(added, line())
+		}
+		if buf.Len()-(end-start)+len(added) == 0 {
+			continue
+		}
+		replace(buf, h, start, end, added)
+		from := r.Intn(buf.Len())
+		checkSpans(t, h, buf, from, from+r.Intn(20))
+	}
+}
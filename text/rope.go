@@ -0,0 +1,148 @@
+package text
+
+// rope is an implicit treap with one line per node. Nodes are ordered by
+// their position in the buffer and heap ordered by a random priority, which
+// keeps the expected depth at O(log n), so Line and Replace are O(log n) plus
+// the number of lines being inserted.
+type rope struct {
+	root *node
+	seed uint64
+}
+
+type node struct {
+	line        string
+	left, right *node
+	size        int
+	priority    uint64
+}
+
+func size(n *node) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *node) update() {
+	n.size = size(n.left) + 1 + size(n.right)
+}
+
+// random is xorshift64*, which is plenty for treap priorities and avoids
+// contention on the global math/rand source.
+func (r *rope) random() uint64 {
+	r.seed ^= r.seed >> 12
+	r.seed ^= r.seed << 25
+	r.seed ^= r.seed >> 27
+	return r.seed * 2685821657736338717
+}
+
+// build constructs a treap from lines in O(len(lines)) by maintaining the
+// right spine of the tree on a stack.
+func (r *rope) build(lines []string) *node {
+	var spine []*node
+	for _, line := range lines {
+		n := &node{line: line, size: 1, priority: r.random()}
+		var last *node
+		for len(spine) > 0 && spine[len(spine)-1].priority < n.priority {
+			last = spine[len(spine)-1]
+			spine = spine[:len(spine)-1]
+			last.update()
+		}
+		n.left = last
+		if len(spine) > 0 {
+			spine[len(spine)-1].right = n
+		}
+		spine = append(spine, n)
+	}
+	for i := len(spine) - 1; i >= 0; i-- {
+		spine[i].update()
+	}
+	if len(spine) == 0 {
+		return nil
+	}
+	return spine[0]
+}
+
+// split returns a treap of the first k lines of n and a treap of the rest.
+func split(n *node, k int) (*node, *node) {
+	if n == nil {
+		return nil, nil
+	}
+	if size(n.left) >= k {
+		l, r := split(n.left, k)
+		n.left = r
+		n.update()
+		return l, n
+	}
+	l, r := split(n.right, k-size(n.left)-1)
+	n.right = l
+	n.update()
+	return n, r
+}
+
+func merge(l, r *node) *node {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if l.priority > r.priority {
+		l.right = merge(l.right, r)
+		l.update()
+		return l
+	}
+	r.left = merge(l, r.left)
+	r.update()
+	return r
+}
+
+func (r *rope) Len() int {
+	return size(r.root)
+}
+
+func (r *rope) Line(y int) string {
+	n := r.root
+	for n != nil {
+		switch {
+		case y < size(n.left):
+			n = n.left
+		case y == size(n.left):
+			return n.line
+		default:
+			y -= size(n.left) + 1
+			n = n.right
+		}
+	}
+	panic("text: line index out of range")
+}
+
+func (r *rope) Lines(start, end int) []string {
+	if start < 0 || end > r.Len() || start > end {
+		panic("text: lines range out of range")
+	}
+	lines := make([]string, 0, end-start)
+	var walk func(n *node, offset int)
+	walk = func(n *node, offset int) {
+		if n == nil || offset >= end || offset+n.size <= start {
+			return
+		}
+		walk(n.left, offset)
+		y := offset + size(n.left)
+		if y >= start && y < end {
+			lines = append(lines, n.line)
+		}
+		walk(n.right, y+1)
+	}
+	walk(r.root, 0)
+	return lines
+}
+
+func (r *rope) Replace(start, end int, lines []string) {
+	if start < 0 || end > r.Len() || start > end {
+		panic("text: replace range out of range")
+	}
+	left, rest := split(r.root, start)
+	_, right := split(rest, end-start)
+	r.root = merge(merge(left, r.build(lines)), right)
+}
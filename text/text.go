@@ -0,0 +1,22 @@
+// Package text provides the line buffer that holds the contents of a file.
+package text
+
+// Buffer is a sequence of lines supporting efficient edits anywhere in the
+// sequence.
+type Buffer interface {
+	// Len returns the number of lines in the buffer.
+	Len() int
+	// Line returns the line at index y.
+	Line(y int) string
+	// Lines returns a fresh slice of the lines in [start, end).
+	Lines(start, end int) []string
+	// Replace replaces the lines in [start, end) with lines.
+	Replace(start, end int, lines []string)
+}
+
+// New returns a Buffer containing lines.
+func New(lines []string) Buffer {
+	r := &rope{seed: 0x9e3779b97f4a7c15}
+	r.root = r.build(lines)
+	return r
+}
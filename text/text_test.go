@@ -0,0 +1,139 @@
+package text
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// check compares b with the lines it should hold, and checks the shape of
+// the treap behind it.
+func check(t *testing.T, b Buffer, want []string) {
+	t.Helper()
+	if b.Len() != len(want) {
+		t.Fatalf("Len is %v, want %v", b.Len(), len(want))
+	}
+	for y, line := range want {
+		if got := b.Line(y); got != line {
+			t.Fatalf("Line(%v) is %q, want %q", y, got, line)
+		}
+	}
+	if got := b.Lines(0, b.Len()); !equal(got, want) {
+		t.Fatalf("Lines is %q, want %q", got, want)
+	}
+	var walk func(n *node) int
+	walk = func(n *node) int {
+		if n == nil {
+			return 0
+		}
+		for _, child := range []*node{n.left, n.right} {
+			if child != nil && child.priority > n.priority {
+				t.Fatal("treap isn't heap ordered")
+			}
+		}
+		if size := walk(n.left) + 1 + walk(n.right); size != n.size {
+			t.Fatalf("node has size %v, but %v nodes", n.size, size)
+		}
+		return n.size
+	}
+	walk(b.(*rope).root)
+}
+
+// equal reports whether a and b hold the same lines, whether or not they're
+// nil.
+func equal(a, b []string) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+func numbered(start, end int) []string {
+	var lines []string
+	for i := start; i < end; i++ {
+		lines = append(lines, fmt.Sprint(i))
+	}
+	return lines
+}
+
+func TestReplace(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		lines      []string
+		start, end int
+		with       []string
+		want       []string
+	}{
+		{"insert at start", numbered(0, 3), 0, 0, []string{"a"},
+			[]string{"a", "0", "1", "2"}},
+		{"insert at end", numbered(0, 3), 3, 3, []string{"a", "b"},
+			[]string{"0", "1", "2", "a", "b"}},
+		{"insert in middle", numbered(0, 3), 1, 1, []string{"a"},
+			[]string{"0", "a", "1", "2"}},
+		{"replace one", numbered(0, 3), 1, 2, []string{"a"},
+			[]string{"0", "a", "2"}},
+		{"replace with more", numbered(0, 3), 0, 2, []string{"a", "b", "c"},
+			[]string{"a", "b", "c", "2"}},
+		{"delete", numbered(0, 3), 0, 2, nil, []string{"2"}},
+		{"delete all", numbered(0, 3), 0, 3, nil, nil},
+		{"into empty", nil, 0, 0, []string{"a"}, []string{"a"}},
+		{"nothing", numbered(0, 3), 2, 2, nil, numbered(0, 3)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := New(test.lines)
+			check(t, b, test.lines)
+			b.Replace(test.start, test.end, test.with)
+			check(t, b, test.want)
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	b := New(numbered(0, 100))
+	for _, r := range [][2]int{{0, 0}, {0, 1}, {10, 20}, {99, 100}, {0, 100}} {
+		got, want := b.Lines(r[0], r[1]), numbered(r[0], r[1])
+		if !equal(got, want) {
+			t.Errorf("Lines(%v, %v) is %q, want %q", r[0], r[1], got, want)
+		}
+	}
+	lines := b.Lines(10, 12)
+	lines[0] = "changed"
+	if b.Line(10) != "10" {
+		t.Error("Lines should return a fresh slice")
+	}
+}
+
+func TestOutOfRange(t *testing.T) {
+	b := New(numbered(0, 3))
+	for name, f := range map[string]func(){
+		"Line(-1)":       func() { b.Line(-1) },
+		"Line(3)":        func() { b.Line(3) },
+		"Lines(2, 1)":    func() { b.Lines(2, 1) },
+		"Lines(0, 4)":    func() { b.Lines(0, 4) },
+		"Replace(-1, 0)": func() { b.Replace(-1, 0, nil) },
+		"Replace(2, 4)":  func() { b.Replace(2, 4, nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v should panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+// TestRandomReplace checks a series of random edits against a slice.
+func TestRandomReplace(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	want := numbered(0, 50)
+	b := New(want)
+	for i := 0; i < 5000; i++ {
+		start := r.Intn(len(want) + 1)
+		end := start + r.Intn(len(want)-start+1)/4
+		with := numbered(1000+i, 1000+i+r.Intn(4))
+		b.Replace(start, end, with)
+		want = append(append(append([]string{}, want[:start]...), with...),
+			want[end:]...)
+		check(t, b, want)
+	}
+}
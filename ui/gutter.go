@@ -0,0 +1,81 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/callum-oakley/vee/state"
+	"github.com/gdamore/tcell/v2"
+)
+
+// A gutter is the columns to the left of a pane's text, with a column for
+// signs and then the line numbers, followed by a blank column. Its width
+// follows the number of lines in the buffer, so it doesn't change as the
+// pane scrolls.
+type gutter struct {
+	numbers state.LineNumbers
+	signs   map[int]state.Sign
+	// digits is the width of the line numbers, or zero if there are none.
+	digits     int
+	signColumn bool
+	cursor     int
+}
+
+func (r *Renderer) gutter(p state.Pane) gutter {
+	g := gutter{
+		numbers: r.S.LineNumbers,
+		signs:   p.Buffer.Signs(),
+		cursor:  p.Selections[0].Cursor.Y,
+	}
+	if g.numbers != state.NoLineNumbers {
+		g.digits = len(strconv.Itoa(p.Buffer.Text.Len()))
+	}
+	switch r.S.SignColumn {
+	case state.AutoSignColumn:
+		g.signColumn = len(g.signs) > 0
+	case state.ShowSignColumn:
+		g.signColumn = true
+	}
+	return g
+}
+
+func (g gutter) width() int {
+	w := g.digits
+	if g.signColumn {
+		w++
+	}
+	if w > 0 {
+		w++
+	}
+	return w
+}
+
+// render draws the gutter beside a row of line y, at screen row sy. Rows
+// that continue a wrapped line are left blank.
+func (g gutter) render(screen tcell.Screen, x, sy int, row row) {
+	if g.width() == 0 || row.continued {
+		return
+	}
+	if sign, ok := g.signs[row.y]; ok && g.signColumn {
+		screen.SetContent(x, sy, sign.Char, nil, styleNamed(sign.Style))
+	}
+	if g.signColumn {
+		x++
+	}
+	if g.digits == 0 {
+		return
+	}
+	n := row.y + 1
+	if g.numbers == state.RelativeLineNumbers ||
+		g.numbers == state.HybridLineNumbers && row.y != g.cursor {
+		n = row.y - g.cursor
+		if n < 0 {
+			n = -n
+		}
+	}
+	style := lineNumberStyle
+	if row.y == g.cursor {
+		style = currentLineNumberStyle
+	}
+	puts(screen, style, x, sy, fmt.Sprintf("%*d", g.digits, n))
+}
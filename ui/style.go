@@ -0,0 +1,132 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/callum-oakley/vee/lsp"
+	"github.com/callum-oakley/vee/state"
+	"github.com/callum-oakley/vee/syntax"
+	"github.com/gdamore/tcell/v2"
+)
+
+func init() {
+	state.RegisterCommand("style", cmdStyle)
+}
+
+var severityNames = map[lsp.DiagnosticSeverity]string{
+	lsp.SeverityError:       "error",
+	lsp.SeverityWarning:     "warning",
+	lsp.SeverityInformation: "information",
+	lsp.SeverityHint:        "hint",
+}
+
+// namedStyles are the styles that aren't for a syntax token or a diagnostic,
+// by name.
+var namedStyles = map[string]*tcell.Style{
+	"status":              &statusStyle,
+	"inactive-status":     &inactiveStatusStyle,
+	"selection":           &selectionStyle,
+	"secondary-selection": &secondarySelectionStyle,
+	"secondary-cursor":    &secondaryCursorStyle,
+	"match":               &matchStyle,
+	"line-number":         &lineNumberStyle,
+	"current-line-number": &currentLineNumberStyle,
+	"indicator":           &indicatorStyle,
+}
+
+// styleNamed returns the style called name, or the default style if there
+// isn't one.
+func styleNamed(name string) tcell.Style {
+	for _, token := range syntax.Tokens {
+		if name == string(token) {
+			return tokenStyles[token]
+		}
+	}
+	for severity, n := range severityNames {
+		if name == n {
+			return diagnosticStyles[severity]
+		}
+	}
+	if style, ok := namedStyles[name]; ok {
+		return *style
+	}
+	return tcell.StyleDefault
+}
+
+// cmdStyle sets the style called name from a list of attributes: fg=colour,
+// bg=colour, bold, dim, italic, underline, reverse, blink, or none, which
+// resets everything before it. Colours are names or #rrggbb.
+func cmdStyle(s *state.State, args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: style name attribute...")
+	}
+	style, err := parseStyle(args[1:])
+	if err != nil {
+		return err
+	}
+	name := args[0]
+	var names []string
+	for _, token := range syntax.Tokens {
+		if name == string(token) {
+			tokenStyles[token] = style
+			return nil
+		}
+		names = append(names, string(token))
+	}
+	for severity, n := range severityNames {
+		if name == n {
+			diagnosticStyles[severity] = style
+			return nil
+		}
+		names = append(names, n)
+	}
+	for n, target := range namedStyles {
+		if name == n {
+			*target = style
+			return nil
+		}
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return fmt.Errorf(
+		"unknown style %q (one of %v)", name, strings.Join(names, ", "),
+	)
+}
+
+func parseStyle(attrs []string) (tcell.Style, error) {
+	style := tcell.StyleDefault
+	for _, attr := range attrs {
+		switch {
+		case strings.HasPrefix(attr, "fg="), strings.HasPrefix(attr, "bg="):
+			colour := tcell.GetColor(attr[3:])
+			if colour == tcell.ColorDefault && attr[3:] != "default" {
+				return style, fmt.Errorf("unknown colour %q", attr[3:])
+			}
+			if attr[0] == 'f' {
+				style = style.Foreground(colour)
+			} else {
+				style = style.Background(colour)
+			}
+		case attr == "bold":
+			style = style.Bold(true)
+		case attr == "dim":
+			style = style.Dim(true)
+		case attr == "italic":
+			style = style.Italic(true)
+		case attr == "underline":
+			style = style.Underline(true)
+		case attr == "reverse":
+			style = style.Reverse(true)
+		case attr == "blink":
+			style = style.Blink(true)
+		case attr == "none":
+			style = tcell.StyleDefault
+		default:
+			return style, fmt.Errorf("unknown attribute %q", attr)
+		}
+	}
+	return style, nil
+}
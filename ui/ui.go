@@ -2,16 +2,54 @@ package ui
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
+	"github.com/callum-oakley/vee/lsp"
 	"github.com/callum-oakley/vee/state"
+	"github.com/callum-oakley/vee/syntax"
 	"github.com/gdamore/tcell/v2"
 	rw "github.com/mattn/go-runewidth"
 )
 
 var (
-	statusStyle    = tcell.StyleDefault.Background(tcell.ColorSilver)
-	selectionStyle = tcell.StyleDefault.Background(tcell.ColorSilver)
+	statusStyle             = tcell.StyleDefault.Background(tcell.ColorSilver)
+	inactiveStatusStyle     = tcell.StyleDefault.Background(tcell.ColorGray)
+	selectionStyle          = tcell.StyleDefault.Background(tcell.ColorSilver)
+	secondarySelectionStyle = tcell.StyleDefault.Background(tcell.ColorGray)
+	secondaryCursorStyle    = tcell.StyleDefault.Reverse(true)
+	matchStyle              = tcell.StyleDefault.Background(tcell.ColorOlive)
+	lineNumberStyle         = tcell.StyleDefault.Foreground(tcell.ColorGray)
+	indicatorStyle          = tcell.StyleDefault.Foreground(tcell.ColorGray)
+	currentLineNumberStyle  = tcell.StyleDefault.Bold(true)
+	tokenStyles             = map[syntax.Token]tcell.Style{
+		syntax.Comment:  tcell.StyleDefault.Foreground(tcell.ColorGray),
+		syntax.Keyword:  tcell.StyleDefault.Foreground(tcell.ColorPurple),
+		syntax.Type:     tcell.StyleDefault.Foreground(tcell.ColorTeal),
+		syntax.String:   tcell.StyleDefault.Foreground(tcell.ColorGreen),
+		syntax.Number:   tcell.StyleDefault.Foreground(tcell.ColorMaroon),
+		syntax.Constant: tcell.StyleDefault.Foreground(tcell.ColorMaroon),
+		syntax.Variable: tcell.StyleDefault.Foreground(tcell.ColorOlive),
+		syntax.Key:      tcell.StyleDefault.Foreground(tcell.ColorNavy),
+		syntax.Heading: tcell.StyleDefault.
+			Foreground(tcell.ColorNavy).
+			Bold(true),
+		syntax.Emphasis: tcell.StyleDefault.Italic(true),
+		syntax.Code:     tcell.StyleDefault.Foreground(tcell.ColorTeal),
+		syntax.Link: tcell.StyleDefault.
+			Foreground(tcell.ColorBlue).
+			Underline(true),
+	}
+	diagnosticStyles = map[lsp.DiagnosticSeverity]tcell.Style{
+		lsp.SeverityError: tcell.StyleDefault.
+			Foreground(tcell.ColorRed).
+			Underline(true),
+		lsp.SeverityWarning: tcell.StyleDefault.
+			Foreground(tcell.ColorYellow).
+			Underline(true),
+		lsp.SeverityInformation: tcell.StyleDefault.Underline(true),
+		lsp.SeverityHint:        tcell.StyleDefault.Underline(true),
+	}
 )
 
 type Renderer struct {
@@ -20,107 +58,436 @@ type Renderer struct {
 	w, h   int
 }
 
-func (r *Renderer) renderText(height int) {
+// A cell is a single character on screen, along with any zero width
+// characters that combine with it.
+type cell struct {
+	x     int // byte offset of the character in its line
+	col   int // screen column of the cell in its row
+	runes []rune
+	width int
+}
+
+// A row is one screen row's worth of cells from line y. It's continued if
+// it isn't the line's first row, and its prefix, if any, is drawn before
+// its cells.
+type row struct {
+	y         int
+	cells     []cell
+	continued bool
+	prefix    string
+}
+
+// layout expands tabs in line y and wraps it into rows of the given width,
+// as wrap says. If pad is set, a blank cell is added at the end of the line
+// for a cursor to sit on.
+func (r *Renderer) layout(
+	y int,
+	line string,
+	pad bool,
+	width int,
+	wrap state.Wrap,
+) []row {
+	if pad {
+		line += " "
+	}
+	if wrap == state.NoWrap {
+		width = math.MaxInt32
+	}
+
+	// Gather the characters into cells, leaving the widths of tabs until
+	// we know where they fall.
+	var cells []cell
+	zwj := false
+	for x, char := range line {
+		if len(cells) > 0 && (zwj || char != '\t' && rw.RuneWidth(char) == 0) {
+			c := &cells[len(cells)-1]
+			c.runes = append(c.runes, char)
+			zwj = char == '\u200d'
+			continue
+		}
+		zwj = false
+		c := cell{x: x, runes: []rune{char}, width: rw.RuneWidth(char)}
+		if c.width == 0 && char != '\t' {
+			c.runes = []rune{' ', char}
+			c.width = 1
+		}
+		cells = append(cells, c)
+	}
+
+	prefix := ""
+	if wrap == state.WordWrap {
+		prefix = r.breakPrefix(cells, width)
+	}
+	rows := []row{{y: y}}
+	used := 0
+	first := 0 // the index in cells of the first cell in the last row
+	for i := 0; i < len(cells); i++ {
+		last := &rows[len(rows)-1]
+		c := cells[i]
+		if c.runes[0] == '\t' {
+			c.width = r.S.TabWidth - used%r.S.TabWidth
+		}
+		if used+c.width > width && len(last.cells) > 0 {
+			// Move back to just after the last break in the row, if there is
+			// one, so that the word that doesn't fit starts the next row.
+			if wrap == state.WordWrap {
+				if k := lastBreak(last.cells); k >= 0 {
+					last.cells = last.cells[:k+1]
+					i = first + k + 1
+					c = cells[i]
+				}
+			}
+			rows = append(rows, row{y: y, continued: true, prefix: prefix})
+			last = &rows[len(rows)-1]
+			used = rw.StringWidth(prefix)
+			first = i
+			if c.runes[0] == '\t' {
+				c.width = r.S.TabWidth - used%r.S.TabWidth
+			}
+		}
+		c.col = used
+		last.cells = append(last.cells, c)
+		used += c.width
+	}
+	return rows
+}
+
+// breakPrefix returns what goes before the cells of a word wrapped line on
+// the rows after its first: as much space as the line is indented, and then
+// the showbreak. Lines too indented to leave room for text after the
+// prefix aren't given one.
+func (r *Renderer) breakPrefix(cells []cell, width int) string {
+	indent := 0
+	for _, c := range cells {
+		if !isSpace(c) {
+			break
+		}
+		if c.runes[0] == '\t' {
+			indent += r.S.TabWidth - indent%r.S.TabWidth
+		} else {
+			indent += c.width
+		}
+	}
+	prefix := strings.Repeat(" ", indent) + r.S.ShowBreak
+	if rw.StringWidth(prefix) > width/2 {
+		return ""
+	}
+	return prefix
+}
+
+// lastBreak returns the index of the last whitespace cell in a row that
+// comes after something else, or -1 if there isn't one. Breaking within
+// the indentation at the start of a line wouldn't help.
+func lastBreak(cells []cell) int {
+	k, text := -1, false
+	for i, c := range cells {
+		if isSpace(c) && text {
+			k = i
+		}
+		text = text || !isSpace(c)
+	}
+	return k
+}
+
+func isSpace(c cell) bool {
+	return c.runes[0] == ' ' && len(c.runes) == 1 || c.runes[0] == '\t'
+}
+
+// renderText draws the text of p's buffer above its status line, scrolled
+// to keep its primary cursor in the middle. Lines that aren't wrapped are
+// scrolled sideways as little as will keep the cursor in view, with
+// indicators where they're cut off.
+func (r *Renderer) renderText(p state.Pane) {
+	b, selections, height := p.Buffer, p.Selections, p.H-1
+	cursor := selections[0].Cursor
+	g := r.gutter(p)
+	width := max(1, p.W-g.width())
+
 	// Discard lines we definitely won't be rendering.
-	start := max(0, min(r.S.Cursor.Y-(height-1)/2, len(r.S.Text)-height))
-	rawLines := r.S.Text[start:min(start+height, len(r.S.Text))]
+	start := max(0, min(cursor.Y-(height-1)/2, b.Text.Len()-height))
+	rawLines := b.Text.Lines(start, min(start+height, b.Text.Len()))
 
 	// Expand tabs and wrap.
-	var lines []string
-	var cursor struct{ x, y int }
-	var anchor struct{ x, y int }
-	if r.S.Anchor.Y >= start+height {
-		anchor.y = height
-	}
-	for y, line := range rawLines {
-		lines = append(lines, "")
-		if len(line) == 0 ||
-			y == r.S.Cursor.Y-start && r.S.Cursor.X == len(line) {
-			line += " "
-		}
-		for x, char := range line {
-			if x == max(0, r.S.Cursor.X) && y == r.S.Cursor.Y-start {
-				cursor.x = rw.StringWidth(lines[len(lines)-1])
-				cursor.y = len(lines) - 1
+	var rows []row
+	var cursorCell cell
+	cursorRow := 0
+	matches := map[int][][]int{}
+	spans := map[int][]syntax.Span{}
+	for i, line := range rawLines {
+		y := start + i
+		if r.S.Search != nil {
+			matches[y] = r.S.Search.FindAllStringIndex(line, -1)
+		}
+		if b.Highlighter != nil {
+			spans[y] = b.Highlighter.Spans(b.Text, y)
+		}
+		pad := len(line) == 0
+		for _, sel := range selections {
+			if sel.Cursor.Y == y && sel.Cursor.X == len(line) {
+				pad = true
 			}
-			if x == max(0, r.S.Anchor.X) && y == r.S.Anchor.Y-start {
-				anchor.x = rw.StringWidth(lines[len(lines)-1])
-				anchor.y = len(lines) - 1
+		}
+		for _, row := range r.layout(y, line, pad, width, b.Wrap) {
+			if y == cursor.Y {
+				for _, c := range row.cells {
+					if c.x == max(0, cursor.X) {
+						cursorRow, cursorCell = len(rows), c
+					}
+				}
 			}
-			if rw.StringWidth(lines[len(lines)-1]+string(char)) > r.w {
-				lines = append(lines, "")
+			rows = append(rows, row)
+		}
+	}
+
+	// Discard the rows that didn't make the cut after wrapping.
+	start = max(0, min(cursorRow-(height-1)/2, len(rows)-height))
+	rows = rows[start:min(start+height, len(rows))]
+
+	left := 0
+	if b.Wrap == state.NoWrap {
+		left = r.scroll(p.Window, cursorCell, width)
+	}
+	visible := func(col int) bool { return col >= left && col < left+width }
+
+	for i, row := range rows {
+		sx, sy := p.X+g.width(), p.Y+i
+		g.render(r.Screen, p.X, sy, row)
+		puts(r.Screen, indicatorStyle, sx, sy, row.prefix)
+		length := len(b.Text.Line(row.y))
+		cursorCol := -1
+		cutLeft, cutRight := false, false
+		for _, c := range row.cells {
+			hidden := !visible(c.col) || !visible(c.col+c.width-1)
+			if hidden && c.x < length {
+				cutLeft = cutLeft || c.col < left
+				cutRight = cutRight || c.col+c.width > left+width
 			}
-			if char == '\t' {
-				lines[len(lines)-1] +=
-					strings.Repeat(
-						" ",
-						r.S.TabWidth-
-							rw.StringWidth(lines[len(lines)-1])%r.S.TabWidth,
-					)
+			// Tabs are drawn as far as they're visible, but other characters
+			// are all or nothing.
+			if hidden && c.runes[0] != '\t' {
+				continue
+			}
+			if p.Focused && row.y == cursor.Y && c.x == max(0, cursor.X) {
+				cursorCol = max(c.col, left)
+				r.Screen.ShowCursor(sx+cursorCol-left, sy)
+			}
+			style := tcell.StyleDefault
+			for _, span := range spans[row.y] {
+				if c.x >= span.Start && c.x < span.End {
+					style = tokenStyles[span.Token]
+				}
+			}
+			for _, m := range matches[row.y] {
+				if c.x >= m[0] && c.x < m[1] {
+					style = blend(style, matchStyle)
+				}
+			}
+			style = diagnosticStyleAt(style, b.Diagnostics, row.y, c.x)
+			style = selectionStyleAt(style, selections, row.y, c.x)
+			if c.runes[0] == '\t' {
+				for col := c.col; col < c.col+c.width; col++ {
+					if visible(col) {
+						r.Screen.SetContent(sx+col-left, sy, ' ', nil, style)
+					}
+				}
 			} else {
-				lines[len(lines)-1] += string(char)
+				r.Screen.SetContent(
+					sx+c.col-left, sy, c.runes[0], c.runes[1:], style,
+				)
 			}
 		}
+		if cutLeft && cursorCol != left {
+			r.Screen.SetContent(sx, sy, '<', nil, indicatorStyle)
+		}
+		if cutRight && cursorCol != left+width-1 {
+			r.Screen.SetContent(sx+width-1, sy, '>', nil, indicatorStyle)
+		}
 	}
+}
 
-	// Discard the lines that didn't make the cut after wrapping.
-	start = max(0, min(cursor.y-(height-1)/2, len(lines)-height))
-	lines = lines[start:min(start+height, len(lines))]
-	cursor.y -= start
-	anchor.y -= start
-	r.Screen.ShowCursor(cursor.x, cursor.y)
+// scroll scrolls w sideways, if it needs to, to keep the cursor, on cell c,
+// at least the side margin away from the edges of text width columns wide,
+// and returns the first column to show.
+func (r *Renderer) scroll(w *state.Window, c cell, width int) int {
+	margin := max(0, min(r.S.SideMargin, (width-c.width)/2))
+	if c.col-margin < w.Column {
+		w.Column = max(0, c.col-margin)
+	}
+	if c.col+c.width+margin > w.Column+width {
+		w.Column = c.col + c.width + margin - width
+	}
+	return w.Column
+}
 
-	if cursor.x == anchor.x && cursor.y == anchor.y {
-		for y, line := range lines {
-			puts(r.Screen, tcell.StyleDefault, 0, y, line)
+// selectionStyleAt returns the style of the character at x on line y
+// according to the selections it falls in, or style if it isn't selected. The
+// first selection is primary.
+func selectionStyleAt(
+	style tcell.Style,
+	selections []state.Selection,
+	y, x int,
+) tcell.Style {
+	for i, sel := range selections {
+		from, to := sel.Anchor, sel.Cursor
+		if to.Y < from.Y || to.Y == from.Y && max(0, to.X) < max(0, from.X) {
+			from, to = to, from
+		}
+		if y < from.Y || y == from.Y && x < max(0, from.X) ||
+			y > to.Y || y == to.Y && x > max(0, to.X) {
+			continue
+		}
+		if i > 0 && y == sel.Cursor.Y && x == max(0, sel.Cursor.X) {
+			return blend(style, secondaryCursorStyle)
+		}
+		if i > 0 {
+			return blend(style, secondarySelectionStyle)
+		}
+		if sel.Anchor.X != sel.Cursor.X || sel.Anchor.Y != sel.Cursor.Y {
+			return blend(style, selectionStyle)
 		}
-		return
 	}
+	return style
+}
 
-	if cursor.y < anchor.y || cursor.y == anchor.y && cursor.x < anchor.x {
-		cursor, anchor = anchor, cursor
+// diagnosticStyleAt returns the style of the character at x on line y
+// according to the diagnostics covering it, or style if there are none.
+func diagnosticStyleAt(
+	style tcell.Style,
+	diagnostics []state.Diagnostic,
+	y, x int,
+) tcell.Style {
+	for _, d := range diagnostics {
+		if y < d.From.Y || y == d.From.Y && x < d.From.X ||
+			y > d.To.Y || y == d.To.Y && x >= d.To.X {
+			continue
+		}
+		over, ok := diagnosticStyles[d.Severity]
+		if !ok {
+			over = diagnosticStyles[lsp.SeverityError]
+		}
+		return blend(style, over)
 	}
+	return style
+}
 
-	for y, line := range lines {
-		if y < anchor.y || y > cursor.y {
-			puts(r.Screen, tcell.StyleDefault, 0, y, line)
-		} else if y == anchor.y && y == cursor.y {
-			x := puts(r.Screen, tcell.StyleDefault, 0, y, line[:anchor.x])
-			x += puts(r.Screen, selectionStyle, x, y, line[anchor.x:cursor.x+1])
-			puts(r.Screen, tcell.StyleDefault, x, y, line[cursor.x+1:])
-		} else if y == anchor.y {
-			x := puts(r.Screen, tcell.StyleDefault, 0, y, line[:anchor.x])
-			puts(r.Screen, selectionStyle, x, y, line[anchor.x:])
-		} else if y == cursor.y {
-			x := puts(r.Screen, selectionStyle, 0, y, line[:cursor.x+1])
-			puts(r.Screen, tcell.StyleDefault, x, y, line[cursor.x+1:])
-		} else { // y > anchor.y && y < cursor.y
-			puts(r.Screen, selectionStyle, 0, y, line)
-		}
+// blend lays over on top of base. Colours that over leaves as the default
+// let base's colours show through, and attributes are combined.
+func blend(base, over tcell.Style) tcell.Style {
+	fg, bg, attrs := base.Decompose()
+	overFg, overBg, overAttrs := over.Decompose()
+	if overFg != tcell.ColorDefault {
+		fg = overFg
+	}
+	if overBg != tcell.ColorDefault {
+		bg = overBg
 	}
+	return tcell.StyleDefault.
+		Foreground(fg).
+		Background(bg).
+		Attributes(attrs | overAttrs)
 }
 
 func padBetween(left, right string, width int) string {
 	return left + strings.Repeat(
 		" ",
-		width-rw.StringWidth(left)-rw.StringWidth(right),
+		max(0, width-rw.StringWidth(left)-rw.StringWidth(right)),
 	) + right
 }
 
-func (r *Renderer) renderStatus(y int) {
-	puts(r.Screen, statusStyle, 0, y, padBetween(
-		r.S.FilePath,
-		fmt.Sprintf("%v,%v", r.S.Cursor.X+1, r.S.Cursor.Y+1),
-		r.w,
-	))
-	puts(r.Screen, tcell.StyleDefault, 0, y+1, r.S.Msg)
+// renderStatus draws p's status line along its bottom row. Only the focused
+// pane's status line shows the state of the editor as a whole.
+func (r *Renderer) renderStatus(p state.Pane) {
+	b, cursor := p.Buffer, p.Selections[0].Cursor
+	left := b.FilePath
+	if left == "" {
+		left = "[no name]"
+	}
+	if b.Modified() {
+		left += " [+]"
+	}
+	if r.S.ReadOnly {
+		left += " [RO]"
+	}
+	style, pending := inactiveStatusStyle, ""
+	if p.Focused {
+		style, pending = statusStyle, r.S.PendingKeys()
+		if slot, ok := r.S.Recording(); ok {
+			left += " recording @" + slot
+		}
+		left += " " + r.S.BufferStatus()
+	}
+	puts(r.Screen, style, p.X, p.Y+p.H-1, rw.Truncate(padBetween(
+		strings.TrimSpace(left),
+		strings.Join(strings.Fields(fmt.Sprintf(
+			"%v %v %v %v,%v",
+			pending, b.DiagnosticStatus(), b.FormatStatus(),
+			cursor.X+1, cursor.Y+1,
+		)), " "),
+		p.W,
+	), p.W, ""))
+}
+
+// renderSeparator draws the column between p and the pane to its right, if
+// there is one.
+func (r *Renderer) renderSeparator(p state.Pane) {
+	if p.X+p.W >= r.w {
+		return
+	}
+	for y := p.Y; y < p.Y+p.H; y++ {
+		r.Screen.SetContent(p.X+p.W, y, '│', nil, tcell.StyleDefault)
+	}
+}
+
+// renderMessage draws the prompt, the latest message, or the diagnostic on
+// the primary cursor's line, on row y.
+func (r *Renderer) renderMessage(y int) {
+	if prompt, ok := r.S.Prompt(); ok {
+		x := puts(r.Screen, tcell.StyleDefault, 0, y, prompt)
+		r.Screen.ShowCursor(x, y)
+	} else if r.S.Msg != "" {
+		puts(r.Screen, tcell.StyleDefault, 0, y, r.S.Msg)
+	} else {
+		puts(r.Screen, tcell.StyleDefault, 0, y, r.S.LineDiagnostic())
+	}
+}
+
+// renderMenu draws the open menu, if there is one, over the bottom of the
+// text, ending just above row y.
+func (r *Renderer) renderMenu(y int) {
+	items, selected, ok := r.S.Menu()
+	if !ok {
+		return
+	}
+	width := 0
+	for _, item := range items {
+		width = max(width, rw.StringWidth(item)+2)
+	}
+	// Show as many items as fit, scrolled to keep the selected item in view.
+	start := max(0, selected-y+1)
+	visible := items[start:min(len(items), start+y)]
+	for i, item := range visible {
+		style := statusStyle
+		if start+i == selected {
+			style = selectionStyle.Reverse(true)
+		}
+		item = padBetween(" "+item, "", width)
+		puts(r.Screen, style, 0, y-len(visible)+i, item)
+	}
+	r.Screen.HideCursor()
 }
 
 func (r *Renderer) Render() {
 	r.w, r.h = r.Screen.Size()
 	r.Screen.Clear()
-	r.renderText(r.h - 2)
-	r.renderStatus(r.h - 2)
+	// The focused window shows the cursor, unless it doesn't fit on screen.
+	r.Screen.HideCursor()
+	for _, p := range r.S.Layout(r.w, r.h-1) {
+		r.renderText(p)
+		r.renderStatus(p)
+		r.renderSeparator(p)
+	}
+	r.renderMenu(r.h - 2)
+	r.renderMessage(r.h - 1)
 	r.Screen.Show()
 }
 